@@ -42,6 +42,7 @@ import (
 type Backend interface {
 	Configure(arguments map[string]interface{}) (err error)
 	Archive(files []string, writer io.WriteCloser) (name string, err error)
+	Extract(reader io.Reader, directory string) (err error)
 	Comments() (comments string)
 	GetConfiguration() interface{}
 }
@@ -30,13 +30,19 @@ THE SOFTWARE.
 package tar
 
 import (
-	"errors"
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/root-gg/plik/client/archive/walk"
+	"github.com/ulikunitz/xz"
 )
 
 // Backend object
@@ -49,11 +55,6 @@ type Backend struct {
 func NewTarBackend(config map[string]interface{}) (tb *Backend, err error) {
 	tb = new(Backend)
 	tb.Config = NewTarBackendConfig(config)
-	if _, err = os.Stat(tb.Config.Tar); os.IsNotExist(err) || os.IsPermission(err) {
-		if tb.Config.Tar, err = exec.LookPath("tar"); err != nil {
-			err = errors.New("tar binary not found in $PATH, please install or edit ~/.plickrc")
-		}
-	}
 	return
 }
 
@@ -62,18 +63,35 @@ func (tb *Backend) Configure(arguments map[string]interface{}) (err error) {
 	if arguments["--compress"] != nil && arguments["--compress"].(string) != "" {
 		tb.Config.Compress = arguments["--compress"].(string)
 	}
-	if arguments["--archive-options"] != nil && arguments["--archive-options"].(string) != "" {
-		tb.Config.Options = arguments["--archive-options"].(string)
+	if arguments["--include"] != nil && arguments["--include"].(string) != "" {
+		tb.Config.Include = strings.Split(arguments["--include"].(string), ",")
+	}
+	if arguments["--exclude"] != nil && arguments["--exclude"].(string) != "" {
+		tb.Config.Exclude = strings.Split(arguments["--exclude"].(string), ",")
+	}
+
+	switch tb.Config.Compress {
+	case "gzip", "bzip2", "xz", "zstd", "no":
+	default:
+		return fmt.Errorf("Unsupported tar compression %s", tb.Config.Compress)
 	}
 	return
 }
 
 // Archive implementation for TAR Archive Backend
+// The archive is written to the writer by a goroutine. Errors
+// are reported to the reader of the pipe when the writer is one.
 func (tb *Backend) Archive(files []string, writer io.WriteCloser) (name string, err error) {
 	if len(files) == 0 {
-		fmt.Println("Unable to make a tar archive from STDIN")
-		os.Exit(1)
-		return
+		return "", fmt.Errorf("Unable to make a tar archive from STDIN")
+	}
+	if tb.Config.Compress == "bzip2" {
+		return "", fmt.Errorf("bzip2 compression is only supported to extract archives")
+	}
+	for _, file := range files {
+		if _, err = os.Lstat(file); err != nil {
+			return "", err
+		}
 	}
 
 	name = "archive"
@@ -82,36 +100,157 @@ func (tb *Backend) Archive(files []string, writer io.WriteCloser) (name string,
 	}
 	name += ".tar" + getCompressExtention(tb.Config.Compress)
 
-	var args []string
-	args = append(args, "--create")
-	if tb.Config.Compress != "no" {
-		args = append(args, "--"+tb.Config.Compress)
+	go func() {
+		err := tb.write(files, writer)
+		if pipeWriter, ok := writer.(*io.PipeWriter); ok {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		writer.Close()
+	}()
+	return
+}
+
+func (tb *Backend) write(files []string, writer io.Writer) (err error) {
+	compressWriter, err := newCompressWriter(tb.Config.Compress, writer)
+	if err != nil {
+		return
 	}
-	args = append(args, strings.Fields(tb.Config.Options)...)
-	args = append(args, files...)
 
-	cmd := exec.Command(tb.Config.Tar, args...)
-	cmd.Stdout = writer
-	cmd.Stderr = os.Stderr
-	go func() {
-		err = cmd.Start()
+	tarWriter := tar.NewWriter(compressWriter)
+	err = walk.Walk(files, tb.Config.Include, tb.Config.Exclude, func(path string, name string, info os.FileInfo) (err error) {
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
-			fmt.Printf("Unable to run tar cmd : %s\n", err)
-			os.Exit(1)
 			return
 		}
-		err = cmd.Wait()
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return
+		}
+		if !info.Mode().IsRegular() {
+			return
+		}
+
+		file, err := os.Open(path)
 		if err != nil {
-			fmt.Printf("Unable to run tar cmd : %s\n", err)
-			os.Exit(1)
 			return
 		}
-		err = writer.Close()
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to make tar archive : %s", err)
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return
+	}
+	return compressWriter.Close()
+}
+
+// Extract implementation for TAR Archive Backend
+func (tb *Backend) Extract(reader io.Reader, directory string) (err error) {
+	compressReader, err := newCompressReader(tb.Config.Compress, reader)
+	if err != nil {
+		return
+	}
+
+	// Directories mtimes are set once their content is extracted
+	type directoryTime struct {
+		path  string
+		mtime time.Time
+	}
+	var directories []directoryTime
+
+	tarReader := tar.NewReader(compressReader)
+	for {
+		var header *tar.Header
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read tar archive : %s", err)
+		}
+
+		var path string
+		path, err = walk.Target(directory, header.Name)
 		if err != nil {
-			fmt.Printf("Unable to run tar cmd : %s\n", err)
 			return
 		}
-	}()
+		if err = os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, mode); err != nil {
+				return
+			}
+			if err = os.Chmod(path, mode); err != nil {
+				return
+			}
+			directories = append(directories, directoryTime{path, header.ModTime})
+			continue
+		case tar.TypeReg:
+			if err = extractFile(tarReader, path, mode); err != nil {
+				return
+			}
+		case tar.TypeSymlink:
+			if err = walk.Symlink(directory, path, header.Linkname); err != nil {
+				return
+			}
+			continue
+		case tar.TypeLink:
+			var target string
+			if target, err = walk.Target(directory, header.Linkname); err != nil {
+				return
+			}
+			os.Remove(path)
+			if err = os.Link(target, path); err != nil {
+				return
+			}
+		default:
+			continue
+		}
+
+		if err = os.Chtimes(path, header.ModTime, header.ModTime); err != nil {
+			return
+		}
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		if err = os.Chtimes(directories[i].path, directories[i].mtime, directories[i].mtime); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+func extractFile(reader io.Reader, path string, mode os.FileMode) (err error) {
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(file, reader)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
 	return
 }
 
@@ -125,6 +264,44 @@ func (tb *Backend) GetConfiguration() interface{} {
 	return tb.Config
 }
 
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func newCompressWriter(mode string, writer io.Writer) (io.WriteCloser, error) {
+	switch mode {
+	case "gzip":
+		return gzip.NewWriter(writer), nil
+	case "xz":
+		return xz.NewWriter(writer)
+	case "zstd":
+		return zstd.NewWriter(writer)
+	case "no":
+		return nopCloser{writer}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported tar compression %s", mode)
+	}
+}
+
+func newCompressReader(mode string, reader io.Reader) (io.Reader, error) {
+	switch mode {
+	case "gzip":
+		return gzip.NewReader(reader)
+	case "bzip2":
+		return bzip2.NewReader(reader), nil
+	case "xz":
+		return xz.NewReader(reader)
+	case "zstd":
+		return zstd.NewReader(reader)
+	case "no":
+		return reader, nil
+	default:
+		return nil, fmt.Errorf("Unsupported tar compression %s", mode)
+	}
+}
+
 func getCompressExtention(mode string) string {
 	switch mode {
 	case "gzip":
@@ -133,14 +310,8 @@ func getCompressExtention(mode string) string {
 		return ".bz2"
 	case "xz":
 		return ".xz"
-	case "lzip":
-		return ".lz"
-	case "lzop":
-		return ".lzo"
-	case "lzma":
-		return ".lzma"
-	case "compres":
-		return ".Z"
+	case "zstd":
+		return ".zst"
 	default:
 		return ""
 	}
@@ -0,0 +1,224 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package walk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Func is called for every file to add to an archive. The name is the
+// path of the file in the archive, relative to the parent directory of
+// the file or directory given on the command line.
+type Func func(path string, name string, info os.FileInfo) error
+
+// Walk calls fn for the files and the content of the directories to archive.
+// Symlinks are not followed. Files matching one of the exclude globs are
+// skipped, and if include globs are given only the files matching one of
+// them are archived. Globs are matched against the file name and its path
+// in the archive.
+func Walk(files []string, include []string, exclude []string, fn Func) (err error) {
+	for _, file := range files {
+		file, err = filepath.Abs(file)
+		if err != nil {
+			return
+		}
+		root := filepath.Dir(file)
+		err = filepath.Walk(file, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+
+			if Match(exclude, name) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() && len(include) > 0 && !Match(include, name) {
+				return nil
+			}
+
+			return fn(path, name, info)
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Policies for the symlinks of the directories uploaded by Tree
+const (
+	SymlinksSkip   = "skip"
+	SymlinksFollow = "follow"
+	SymlinksWithin = "within"
+)
+
+// Tree calls fn for the regular files of the directories to upload one
+// by one. Symlinks are skipped, followed, or only followed within the
+// directory given on the command line depending on the policy. Globs are
+// matched like with Walk. Each directory is only visited once so links
+// to a parent directory can't make an infinite loop.
+func Tree(files []string, include []string, exclude []string, symlinks string, fn Func) (err error) {
+	switch symlinks {
+	case SymlinksSkip, SymlinksFollow, SymlinksWithin:
+	default:
+		return fmt.Errorf("Invalid symlinks policy %s (must be skip, follow or within)", symlinks)
+	}
+
+	visited := make(map[string]bool)
+	for _, file := range files {
+		file, err = filepath.Abs(file)
+		if err != nil {
+			return
+		}
+		top, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			return err
+		}
+		err = tree(top, file, filepath.Base(file), include, exclude, symlinks, visited, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func tree(top string, path string, name string, include []string, exclude []string, symlinks string, visited map[string]bool, fn Func) (err error) {
+	if Match(exclude, name) {
+		return
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if symlinks == SymlinksSkip {
+			return
+		}
+		var target string
+		target, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("Unable to follow symlink %s : %s", path, err)
+		}
+		if symlinks == SymlinksWithin && !Within(top, target) {
+			return
+		}
+		info, err = os.Stat(target)
+		if err != nil {
+			return
+		}
+	}
+
+	if info.IsDir() {
+		var real string
+		real, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return
+		}
+		if visited[real] {
+			return
+		}
+		visited[real] = true
+
+		var entries []os.FileInfo
+		entries, err = ioutil.ReadDir(path)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			err = tree(top, filepath.Join(path, entry.Name()), name+"/"+entry.Name(), include, exclude, symlinks, visited, fn)
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	// Sockets, devices, ... can't be uploaded
+	if !info.Mode().IsRegular() {
+		return
+	}
+	if len(include) > 0 && !Match(include, name) {
+		return
+	}
+	return fn(path, name, info)
+}
+
+// Match tells if the file name or one of the trailing
+// parts of its path match one of the globs
+func Match(globs []string, name string) bool {
+	parts := strings.Split(name, "/")
+	for _, glob := range globs {
+		for i := range parts {
+			if ok, _ := filepath.Match(glob, strings.Join(parts[i:], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Target returns the path where an archive entry has to be extracted
+// and refuses entries that would be written outside of the directory
+func Target(directory string, name string) (path string, err error) {
+	path = filepath.Join(directory, filepath.FromSlash(name))
+	if !Within(directory, path) {
+		return "", fmt.Errorf("Invalid path %s in archive", name)
+	}
+	return
+}
+
+// Within tells if the path is inside the directory
+func Within(directory string, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(directory), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Symlink creates a symlink extracted from an archive. Links
+// pointing outside of the directory are refused.
+func Symlink(directory string, path string, target string) (err error) {
+	if filepath.IsAbs(target) || !Within(directory, filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("Invalid symlink %s -> %s in archive", path, target)
+	}
+	os.Remove(path)
+	return os.Symlink(target, path)
+}
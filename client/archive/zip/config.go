@@ -35,15 +35,14 @@ import (
 
 // BackendConfig object
 type BackendConfig struct {
-	Zip     string
-	Options string
+	Include []string
+	Exclude []string
 }
 
 // NewZipBackendConfig instantiate a new Backend Configuration
 // from config map passed as argument
 func NewZipBackendConfig(config map[string]interface{}) (zb *BackendConfig) {
 	zb = new(BackendConfig)
-	zb.Zip = "/bin/zip"
 	utils.Assign(zb, config)
 	return
 }
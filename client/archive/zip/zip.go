@@ -30,13 +30,15 @@ THE SOFTWARE.
 package zip
 
 import (
-	"errors"
+	"archive/zip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/root-gg/plik/client/archive/walk"
 )
 
 // Backend config
@@ -49,28 +51,31 @@ type Backend struct {
 func NewZipBackend(config map[string]interface{}) (zb *Backend, err error) {
 	zb = new(Backend)
 	zb.Config = NewZipBackendConfig(config)
-	if _, err := os.Stat(zb.Config.Zip); os.IsNotExist(err) || os.IsPermission(err) {
-		if zb.Config.Zip, err = exec.LookPath("zip"); err != nil {
-			err = errors.New("zip binary not found in $PATH, please install or edit ~/.plickrc")
-		}
-	}
 	return
 }
 
 // Configure implementation for ZIP Archive Backend
 func (zb *Backend) Configure(arguments map[string]interface{}) (err error) {
-	if arguments["--archive-options"] != nil && arguments["--archive-options"].(string) != "" {
-		zb.Config.Options = arguments["--archive-options"].(string)
+	if arguments["--include"] != nil && arguments["--include"].(string) != "" {
+		zb.Config.Include = strings.Split(arguments["--include"].(string), ",")
+	}
+	if arguments["--exclude"] != nil && arguments["--exclude"].(string) != "" {
+		zb.Config.Exclude = strings.Split(arguments["--exclude"].(string), ",")
 	}
 	return
 }
 
 // Archive implementation for ZIP Archive Backend
+// The archive is written to the writer by a goroutine. Errors
+// are reported to the reader of the pipe when the writer is one.
 func (zb *Backend) Archive(files []string, writer io.WriteCloser) (name string, err error) {
 	if len(files) == 0 {
-		fmt.Println("Unable to make a zip archive from STDIN")
-		os.Exit(1)
-		return
+		return "", fmt.Errorf("Unable to make a zip archive from STDIN")
+	}
+	for _, file := range files {
+		if _, err = os.Lstat(file); err != nil {
+			return "", err
+		}
 	}
 
 	name = "archive"
@@ -79,36 +84,165 @@ func (zb *Backend) Archive(files []string, writer io.WriteCloser) (name string,
 	}
 	name += ".zip"
 
-	var args []string
-	args = append(args, strings.Fields(zb.Config.Options)...)
-	args = append(args, "-r", "-")
-	args = append(args, files...)
-
-	cmd := exec.Command(zb.Config.Zip, args...)
-	cmd.Stdout = writer
-	cmd.Stderr = os.Stderr
 	go func() {
-		err = cmd.Start()
+		err := zb.write(files, writer)
+		if pipeWriter, ok := writer.(*io.PipeWriter); ok {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		writer.Close()
+	}()
+	return
+}
+
+func (zb *Backend) write(files []string, writer io.Writer) (err error) {
+	zipWriter := zip.NewWriter(writer)
+	err = walk.Walk(files, zb.Config.Include, zb.Config.Exclude, func(path string, name string, info os.FileInfo) (err error) {
+		header, err := zip.FileInfoHeader(info)
 		if err != nil {
-			fmt.Printf("Unable to run zip cmd : %s\n", err)
-			os.Exit(1)
 			return
 		}
-		err = cmd.Wait()
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		entry, err := zipWriter.CreateHeader(header)
 		if err != nil {
-			fmt.Printf("Unable to run zip cmd : %s\n", err)
-			os.Exit(1)
 			return
 		}
-		err = writer.Close()
+
+		// Symlinks are stored with their target as content
+		if info.Mode()&os.ModeSymlink != 0 {
+			var link string
+			if link, err = os.Readlink(path); err != nil {
+				return
+			}
+			_, err = io.WriteString(entry, link)
+			return
+		}
+		if !info.Mode().IsRegular() {
+			return
+		}
+
+		file, err := os.Open(path)
 		if err != nil {
-			fmt.Printf("Unable to run zip cmd : %s\n", err)
 			return
 		}
-	}()
+		defer file.Close()
+
+		_, err = io.Copy(entry, file)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to make zip archive : %s", err)
+	}
+
+	return zipWriter.Close()
+}
+
+// Extract implementation for ZIP Archive Backend
+// The central directory of ZIP archives is at the end
+// so the archive is saved to a temporary file first
+func (zb *Backend) Extract(reader io.Reader, directory string) (err error) {
+	tmp, err := ioutil.TempFile(directory, ".plik-zip-")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file : %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, reader)
+	if err != nil {
+		return fmt.Errorf("Unable to save zip archive : %s", err)
+	}
+
+	zipReader, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return fmt.Errorf("Unable to read zip archive : %s", err)
+	}
+
+	// Directories mtimes are set once their content is extracted
+	var directories []*zip.File
+	for _, entry := range zipReader.File {
+		var path string
+		path, err = walk.Target(directory, entry.Name)
+		if err != nil {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return
+		}
+
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			if err = os.MkdirAll(path, mode.Perm()); err != nil {
+				return
+			}
+			if err = os.Chmod(path, mode.Perm()); err != nil {
+				return
+			}
+			directories = append(directories, entry)
+		case mode&os.ModeSymlink != 0:
+			if err = extractSymlink(entry, directory, path); err != nil {
+				return
+			}
+		default:
+			if err = extractFile(entry, path); err != nil {
+				return
+			}
+		}
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		path := filepath.Join(directory, filepath.FromSlash(directories[i].Name))
+		if err = os.Chtimes(path, directories[i].Modified, directories[i].Modified); err != nil {
+			return
+		}
+	}
 	return
 }
 
+func extractFile(entry *zip.File, path string) (err error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode().Perm())
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(file, reader)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return
+	}
+
+	return os.Chtimes(path, entry.Modified, entry.Modified)
+}
+
+func extractSymlink(entry *zip.File, directory string, path string) (err error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	link, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return
+	}
+	return walk.Symlink(directory, path, string(link))
+}
+
 // Comments implementation for ZIP Archive Backend
 // Left empty because ZIP can't accept piping to it's STDIN
 func (zb *Backend) Comments() string {
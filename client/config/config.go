@@ -32,6 +32,7 @@ package config
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -41,7 +42,15 @@ import (
 	"github.com/BurntSushi/toml"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/root-gg/plik/client/archive"
+	"github.com/root-gg/plik/client/archive/tar"
+	"github.com/root-gg/plik/client/archive/walk"
+	"github.com/root-gg/plik/client/archive/zip"
 	"github.com/root-gg/plik/client/crypto"
+	"github.com/root-gg/plik/client/crypto/age"
+	"github.com/root-gg/plik/client/crypto/e2e"
+	"github.com/root-gg/plik/client/crypto/openssl"
+	"github.com/root-gg/plik/client/crypto/pgp"
+	"github.com/root-gg/plik/client/prompt"
 	"github.com/root-gg/plik/server/common"
 	"github.com/root-gg/utils"
 )
@@ -55,6 +64,10 @@ var Upload *common.Upload
 // Static files array
 var Files []*FileToUpload
 
+// Limits and features of the server, nil if the server
+// does not advertise them
+var Server *common.PublicConfiguration
+
 // Private backends
 var cryptoBackend crypto.Backend
 var archiveBackend archive.Backend
@@ -65,8 +78,11 @@ var longestFilenameSize int
 type UploadConfig struct {
 	Debug          bool
 	Quiet          bool
+	JSON           bool
+	NonInteractive bool
 	HomeDir        string
 	URL            string
+	Token          string
 	OneShot        bool
 	Removable      bool
 	Secure         bool
@@ -75,11 +91,16 @@ type UploadConfig struct {
 	Archive        bool
 	ArchiveMethod  string
 	ArchiveOptions map[string]interface{}
+	Recursive      bool
+	Symlinks       string
 	DownloadBinary string
 	Comments       string
 	Yubikey        bool
 	Password       string
 	TTL            int
+	Parallelism    int
+	Retries        int
+	History        bool
 }
 
 // NewUploadConfig construct a new configuration with default values
@@ -87,17 +108,20 @@ func NewUploadConfig() (config *UploadConfig) {
 	config = new(UploadConfig)
 	config.Debug = false
 	config.Quiet = false
+	config.JSON = false
+	config.NonInteractive = false
 	config.URL = "http://127.0.0.1:8080"
+	config.Token = ""
 	config.OneShot = false
 	config.Removable = false
 	config.Secure = false
 	config.Archive = false
 	config.ArchiveMethod = "tar"
 	config.ArchiveOptions = make(map[string]interface{})
-	config.ArchiveOptions["Tar"] = "/bin/tar"
 	config.ArchiveOptions["Compress"] = "gzip"
-	config.ArchiveOptions["Options"] = ""
-	config.SecureMethod = "openssl"
+	config.Recursive = false
+	config.Symlinks = walk.SymlinksSkip
+	config.SecureMethod = "age"
 	config.SecureOptions = make(map[string]interface{})
 	config.SecureOptions["Openssl"] = "/usr/bin/openssl"
 	config.SecureOptions["Cipher"] = "aes-256-cbc"
@@ -106,26 +130,42 @@ func NewUploadConfig() (config *UploadConfig) {
 	config.Yubikey = false
 	config.Password = ""
 	config.TTL = 86400 * 30
+	config.Parallelism = 4
+	config.Retries = 3
+	config.History = true
 	return
 }
 
 // FileToUpload is a handy struct to gather information
 // about a file to be uploaded
 type FileToUpload struct {
-	Path       string
-	Base       string
-	Size       int64
-	FileHandle *os.File
+	Path string
+	Base string
+	Name string
+	Size int64
 }
 
-// Load creates a new default configuration and override it with .plikrc fike.
-// If plikrc does not exist, ask domain,
-// and create a new one in user HOMEDIR
-func Load() (err error) {
+// Load creates a new default configuration and override it with .plikrc fike,
+// the profile selected with --profile or PLIK_PROFILE and the PLIK_* environment
+// variables. If plikrc does not exist, ask domain, and create a new one in user
+// HOMEDIR unless plik is not run interactively.
+func Load(arguments map[string]interface{}) (err error) {
 	Config = NewUploadConfig()
 	Upload = common.NewUpload()
 	Files = make([]*FileToUpload, 0)
 
+	// Never prompt in non-interactive mode
+	nonInteractive, _ := strconv.ParseBool(os.Getenv(envName("NonInteractive")))
+	if arguments["--non-interactive"].(bool) {
+		nonInteractive = true
+	}
+	prompt.NonInteractive = nonInteractive
+
+	profile := os.Getenv("PLIK_PROFILE")
+	if arguments["--profile"] != nil && arguments["--profile"].(string) != "" {
+		profile = arguments["--profile"].(string)
+	}
+
 	// Detect home dir
 	home, err := homedir.Dir()
 	if err != nil {
@@ -137,11 +177,12 @@ func Load() (err error) {
 	// Stat file
 	configFile := home + "/.plikrc"
 	_, err = os.Stat(configFile)
-	if err != nil {
+	if err != nil && profile != "" {
+		return fmt.Errorf("Profile %s not found : ~/.plikrc does not exist", profile)
+	} else if err != nil && !nonInteractive && prompt.IsTerminal() {
 		// File not present. Ask for domain
 		var domain string
-		fmt.Printf("Please enter your plik domain [default:http://127.0.0.1:8080] : ")
-		_, err := fmt.Scanf("%s", &domain)
+		err := prompt.Ask("Please enter your plik domain [default:http://127.0.0.1:8080] : ", &domain)
 		if err == nil {
 			Config.URL = domain
 			if !strings.HasPrefix(domain, "http") {
@@ -163,15 +204,51 @@ func Load() (err error) {
 
 		f.Write(buf.Bytes())
 		f.Close()
-	} else {
+	} else if err == nil {
 		// Load toml
 		if _, err := toml.DecodeFile(configFile, &Config); err != nil {
 			return fmt.Errorf("Failed to deserialize ~/.plickrc : %s", err)
 		}
+
+		// Profile values override the default ones
+		if profile != "" {
+			rc := new(plikrc)
+			metadata, err := toml.DecodeFile(configFile, rc)
+			if err != nil {
+				return fmt.Errorf("Failed to deserialize ~/.plickrc : %s", err)
+			}
+			if _, ok := rc.Profiles[profile]; !ok {
+				return fmt.Errorf("Profile %s not found in ~/.plikrc", profile)
+			}
+			if err = metadata.PrimitiveDecode(rc.Profiles[profile], Config); err != nil {
+				return fmt.Errorf("Invalid profile %s in ~/.plikrc : %s", profile, err)
+			}
+		}
 	}
+
+	// Environment variables override the configuration file
+	err = loadEnv(Config)
+	if err != nil {
+		return
+	}
+	if nonInteractive {
+		Config.NonInteractive = true
+	}
+	prompt.NonInteractive = Config.NonInteractive
+
 	return
 }
 
+// plikrc has the named profiles of the configuration file.
+// A profile has the configuration values to override :
+//
+//	[Profiles.internal]
+//	URL = "https://plik.internal"
+//	Token = "..."
+type plikrc struct {
+	Profiles map[string]toml.Primitive
+}
+
 // UnmarshalArgs into upload informations
 // Argument takes priority over config file param
 func UnmarshalArgs(arguments map[string]interface{}) (err error) {
@@ -183,6 +260,14 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 	if arguments["--quiet"].(bool) {
 		Config.Quiet = true
 	}
+	if arguments["--json"].(bool) {
+		Config.JSON = true
+	}
+
+	// Nothing but the JSON document is written to stdout
+	if Config.JSON {
+		Config.Quiet = true
+	}
 
 	Debug("Arguments : " + Sdump(arguments))
 	Debug("Configuration : " + Sdump(Config))
@@ -192,6 +277,17 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 		Config.URL = arguments["--server"].(string)
 	}
 
+	// Upload the files of the directories one by one with their path
+	if arguments["--recursive"].(bool) {
+		Config.Recursive = true
+	}
+	if arguments["--symlinks"] != nil && arguments["--symlinks"].(string) != "" {
+		Config.Symlinks = arguments["--symlinks"].(string)
+	}
+	if Config.Recursive && (arguments["-a"].(bool) || arguments["--archive"] != nil) {
+		return fmt.Errorf("--recursive can't be used with --archive\n")
+	}
+
 	// Check files
 	if _, ok := arguments["FILE"].([]string); ok {
 
@@ -214,7 +310,14 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 
 			// Check mode
 			// Enable archive if one of them is a directory
-			if fileInfo.Mode().IsDir() {
+			// unless directories are uploaded file by file
+			if fileInfo.Mode().IsDir() && Config.Recursive {
+				err = addTree(filePath, arguments)
+				if err != nil {
+					return err
+				}
+				continue
+			} else if fileInfo.Mode().IsDir() {
 				Config.Archive = true
 			} else if fileInfo.Mode().IsRegular() {
 				fileToUpload.Size = fileInfo.Size()
@@ -245,23 +348,39 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 	// Upload time to live
 	Upload.TTL = Config.TTL
 	if arguments["--ttl"] != nil && arguments["--ttl"].(string) != "" {
-		ttlStr := arguments["--ttl"].(string)
-		mul := 1
-		if string(ttlStr[len(ttlStr)-1]) == "m" {
-			mul = 60
-		} else if string(ttlStr[len(ttlStr)-1]) == "h" {
-			mul = 3600
-		} else if string(ttlStr[len(ttlStr)-1]) == "d" {
-			mul = 86400
-		}
-		if mul != 1 {
-			ttlStr = ttlStr[:len(ttlStr)-1]
-		}
-		ttl, err := strconv.Atoi(ttlStr)
+		Upload.TTL, err = common.ParseTTL(arguments["--ttl"].(string))
 		if err != nil {
-			return fmt.Errorf("Invalid TTL %s", arguments["--ttl"].(string))
+			return
 		}
-		Upload.TTL = ttl * mul
+	}
+
+	// Fail before prompting for anything if the server would refuse the upload
+	err = checkServer(arguments)
+	if err != nil {
+		return
+	}
+
+	// Concurrent uploads and retries of failed uploads
+	if arguments["--parallel"] != nil && arguments["--parallel"].(string) != "" {
+		Config.Parallelism, err = strconv.Atoi(arguments["--parallel"].(string))
+		if err != nil || Config.Parallelism < 1 {
+			return fmt.Errorf("Invalid parallelism %s\n", arguments["--parallel"].(string))
+		}
+	}
+	if arguments["--retries"] != nil && arguments["--retries"].(string) != "" {
+		Config.Retries, err = strconv.Atoi(arguments["--retries"].(string))
+		if err != nil || Config.Retries < 0 {
+			return fmt.Errorf("Invalid number of retries %s\n", arguments["--retries"].(string))
+		}
+	}
+
+	// End-to-end encryption
+	if arguments["--e2e"].(bool) {
+		if arguments["--secure"] != nil {
+			return fmt.Errorf("--e2e can't be used with --secure\n")
+		}
+		Config.Secure = true
+		Config.SecureMethod = "e2e"
 	}
 
 	// Do we need a crypto backend ?
@@ -301,30 +420,18 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 		}
 
 		Debug("Archive backend configuration : " + utils.Sdump(archiveBackend.GetConfiguration()))
-	} else {
-		for _, fileToUpload := range Files {
-			fh, err := os.Open(fileToUpload.Path)
-			if err != nil {
-				return fmt.Errorf("Unable to open %s : %s", fileToUpload.Path, err)
-			}
-
-			fileToUpload.FileHandle = fh
-		}
 	}
 
 	// Do user wants a password protected upload ?
 	if arguments["-p"].(bool) {
-		fmt.Printf("Login [plik]: ")
-		var err error
-		_, err = fmt.Scanln(&Upload.Login)
+		err := prompt.Ask("Login [plik]: ", &Upload.Login)
 		if err != nil && err.Error() != "unexpected newline" {
 			return fmt.Errorf("Unable to get login : %s", err)
 		}
 		if Upload.Login == "" {
 			Upload.Login = "plik"
 		}
-		fmt.Printf("Password: ")
-		_, err = fmt.Scanln(&Upload.Password)
+		err = prompt.Ask("Password: ", &Upload.Password)
 		if err != nil {
 			return fmt.Errorf("Unable to get password : %s", err)
 		}
@@ -345,13 +452,257 @@ func UnmarshalArgs(arguments map[string]interface{}) (err error) {
 
 	// User wants Yubikey protected upload ?
 	if Config.Yubikey || arguments["--yubikey"].(bool) {
-		fmt.Printf("Yubikey token : ")
-		_, err := fmt.Scanln(&Upload.Yubikey)
+		err := prompt.Ask("Yubikey token : ", &Upload.Yubikey)
 		if err != nil {
 			return fmt.Errorf("Unable to get yubikey token : %s", err)
 		}
 	}
 
+	// Tell plik get how to restore the files
+	if comment := backendsComment(); comment != "" {
+		if Upload.Comments != "" {
+			Upload.Comments += "\n\n"
+		}
+		Upload.Comments += comment
+	}
+
+	// The server only gets encrypted comments
+	if e2eBackend := GetE2EBackend(); e2eBackend != nil {
+		var comments string
+		comments, err = e2eBackend.EncryptString(Upload.Comments)
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt comments : %s\n", err)
+		}
+		Upload.Comments = e2eCommentsPrefix + comments
+	}
+
+	return
+}
+
+// checkServer checks the upload options against the limits
+// and the features advertised by the server
+func checkServer(arguments map[string]interface{}) (err error) {
+	if Server == nil {
+		return
+	}
+
+	if Server.MaxTTL > 0 {
+		if Upload.TTL < 0 {
+			return fmt.Errorf("Cannot set infinite ttl (maximum allowed is : %d)", Server.MaxTTL)
+		}
+		if Upload.TTL > Server.MaxTTL {
+			return fmt.Errorf("Cannot set ttl to %d (maximum allowed is : %d)", Upload.TTL, Server.MaxTTL)
+		}
+	}
+
+	if (Config.Yubikey || arguments["--yubikey"].(bool)) && !Server.YubikeyEnabled {
+		return fmt.Errorf("Yubikey is disabled on this server")
+	}
+
+	// Archives are built on the fly so only files sent as is are checked
+	if Server.MaxFileSize > 0 && !Config.Archive && !arguments["-a"].(bool) && arguments["--archive"] == nil {
+		for _, fileToUpload := range Files {
+			if fileToUpload.Size > int64(Server.MaxFileSize) {
+				return fmt.Errorf("File %s is too big (limit is set to %d bytes)", fileToUpload.Path, Server.MaxFileSize)
+			}
+		}
+	}
+	return
+}
+
+// HistoryFile returns the path of the history of the uploads
+func HistoryFile() string {
+	return filepath.Join(Config.HomeDir, ".plik", "history.json")
+}
+
+// addTree adds the files of a directory to the files to upload
+// with their path relative to the parent of the directory
+func addTree(directory string, arguments map[string]interface{}) (err error) {
+	var include, exclude []string
+	if arguments["--include"] != nil && arguments["--include"].(string) != "" {
+		include = strings.Split(arguments["--include"].(string), ",")
+	}
+	if arguments["--exclude"] != nil && arguments["--exclude"].(string) != "" {
+		exclude = strings.Split(arguments["--exclude"].(string), ",")
+	}
+
+	count := len(Files)
+	err = walk.Tree([]string{directory}, include, exclude, Config.Symlinks, func(path string, name string, info os.FileInfo) (err error) {
+		fileToUpload := new(FileToUpload)
+		fileToUpload.Path = path
+		fileToUpload.Base = filepath.Base(path)
+		fileToUpload.Name = name
+		fileToUpload.Size = info.Size()
+
+		if len(name) > longestFilenameSize {
+			longestFilenameSize = len(name)
+		}
+
+		Files = append(Files, fileToUpload)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to list files of %s : %s", directory, err)
+	}
+	if len(Files) == count {
+		return fmt.Errorf("No files to upload in %s", directory)
+	}
+	return
+}
+
+// UnmarshalGetArgs handles the arguments of the get command
+// and of the commands managing the uploads of the history
+func UnmarshalGetArgs(arguments map[string]interface{}) (err error) {
+	if arguments["--debug"].(bool) {
+		Config.Debug = true
+	}
+	if arguments["--quiet"].(bool) {
+		Config.Quiet = true
+	}
+	if arguments["--json"].(bool) {
+		Config.JSON = true
+	}
+
+	Debug("Arguments : " + Sdump(arguments))
+	Debug("Configuration : " + Sdump(Config))
+
+	return
+}
+
+// Uploads made with crypto or archive backends have a line in their comments
+// listing the backends used ( `plik-client: openssl aes256 | tar gzip` )
+const backendsCommentPrefix = "plik-client:"
+
+// Comments of end-to-end encrypted uploads are encrypted
+const e2eCommentsPrefix = "plik-e2e:"
+
+func backendsComment() string {
+	var stages []string
+	if Config.Secure {
+		switch backendConfig := cryptoBackend.GetConfiguration().(type) {
+		case *openssl.BackendConfig:
+			stages = append(stages, "openssl "+backendConfig.Cipher)
+		case *pgp.BackendConfig:
+			if backendConfig.Symmetric {
+				stages = append(stages, "pgp symmetric")
+			} else {
+				stages = append(stages, "pgp")
+			}
+		case *age.BackendConfig:
+			stages = append(stages, "age")
+		case *e2e.BackendConfig:
+			stages = append(stages, "e2e")
+		}
+	}
+	if Config.Archive {
+		switch backendConfig := archiveBackend.GetConfiguration().(type) {
+		case *tar.BackendConfig:
+			stages = append(stages, "tar "+backendConfig.Compress)
+		case *zip.BackendConfig:
+			stages = append(stages, "zip")
+		}
+	}
+	if len(stages) == 0 {
+		return ""
+	}
+	return "`" + backendsCommentPrefix + " " + strings.Join(stages, " | ") + "`"
+}
+
+// ConfigureRestore sets up the crypto and archive backends needed to
+// restore the files of an upload from the backends listed in its comments
+func ConfigureRestore(comments string, arguments map[string]interface{}) (err error) {
+
+	// Backends options come from the comments
+	// but are overridden by command line arguments
+	args := make(map[string]interface{})
+	for key, value := range arguments {
+		args[key] = value
+	}
+
+	// The key of end-to-end encrypted uploads is needed to read the comments
+	if strings.HasPrefix(comments, e2eCommentsPrefix) {
+		if args["--key"] == nil {
+			return errors.New("This upload is end-to-end encrypted, use the link with the key to download it")
+		}
+		Config.Secure = true
+		Config.SecureMethod = "e2e"
+		cryptoBackend, err = crypto.NewCryptoBackend("e2e", Config.SecureOptions)
+		if err != nil {
+			return fmt.Errorf("Invalid secure params : %s", err)
+		}
+		if err = cryptoBackend.Configure(args); err != nil {
+			return fmt.Errorf("Invalid secure params : %s", err)
+		}
+		comments, err = GetE2EBackend().DecryptString(strings.TrimPrefix(comments, e2eCommentsPrefix))
+		if err != nil {
+			return
+		}
+	}
+
+	i := strings.Index(comments, backendsCommentPrefix)
+	if i < 0 {
+		return
+	}
+	line := comments[i+len(backendsCommentPrefix):]
+	if j := strings.IndexAny(line, "`\n"); j >= 0 {
+		line = line[:j]
+	}
+
+	for _, stage := range strings.Split(line, "|") {
+		fields := strings.Fields(stage)
+		if len(fields) == 0 {
+			continue
+		}
+		option := ""
+		if len(fields) > 1 {
+			option = fields[1]
+		}
+
+		switch fields[0] {
+		case "e2e":
+			// Configured with the comments
+		case "age", "openssl", "pgp":
+			Config.Secure = true
+			Config.SecureMethod = fields[0]
+			cryptoBackend, err = crypto.NewCryptoBackend(fields[0], Config.SecureOptions)
+			if err != nil {
+				return fmt.Errorf("Invalid secure params : %s", err)
+			}
+
+			// Files encrypted for a pgp recipient are decrypted by gpg
+			if fields[0] == "pgp" && option != "symmetric" {
+				continue
+			}
+
+			if fields[0] == "openssl" && args["--cipher"] == nil && option != "" {
+				args["--cipher"] = option
+			}
+			if args["--passphrase"] == nil {
+				args["--passphrase"] = "-"
+			}
+			err = cryptoBackend.Configure(args)
+			if err != nil {
+				return fmt.Errorf("Invalid secure params : %s", err)
+			}
+		case "tar", "zip":
+			Config.Archive = true
+			Config.ArchiveMethod = fields[0]
+			archiveBackend, err = archive.NewArchiveBackend(fields[0], Config.ArchiveOptions)
+			if err != nil {
+				return fmt.Errorf("Invalid archive params : %s", err)
+			}
+			if args["--compress"] == nil && option != "" {
+				args["--compress"] = option
+			}
+			err = archiveBackend.Configure(args)
+			if err != nil {
+				return fmt.Errorf("Invalid archive params : %s", err)
+			}
+		default:
+			return fmt.Errorf("Unknown backend %s", fields[0])
+		}
+	}
+
 	return
 }
 
@@ -366,6 +717,13 @@ func GetArchiveBackend() archive.Backend {
 	return archiveBackend
 }
 
+// GetE2EBackend returns the crypto backend
+// if the upload is end-to-end encrypted
+func GetE2EBackend() *e2e.Backend {
+	e2eBackend, _ := cryptoBackend.(*e2e.Backend)
+	return e2eBackend
+}
+
 // GetCryptoBackend is a getter for crypto backend
 func GetCryptoBackend() crypto.Backend {
 	return cryptoBackend
@@ -0,0 +1,100 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"unicode"
+)
+
+// envName returns the environment variable overriding
+// a configuration field ( SecureMethod => PLIK_SECURE_METHOD )
+func envName(field string) string {
+	runes := []rune(field)
+	name := []rune("PLIK_")
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return string(name)
+}
+
+// loadEnv overrides the configuration fields with the PLIK_* environment
+// variables. Options maps are given as a JSON object whose values are
+// merged into the configured options.
+func loadEnv(config *UploadConfig) (err error) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := envName(value.Type().Field(i).Name)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(env)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(env)
+			if err != nil {
+				return fmt.Errorf("Invalid boolean %s=%s", name, env)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			var n int
+			n, err = strconv.Atoi(env)
+			if err != nil {
+				return fmt.Errorf("Invalid number %s=%s", name, env)
+			}
+			field.SetInt(int64(n))
+		case reflect.Map:
+			options := make(map[string]interface{})
+			err = json.Unmarshal([]byte(env), &options)
+			if err != nil {
+				return fmt.Errorf("Invalid JSON object %s=%s : %s", name, env, err)
+			}
+			if field.IsNil() {
+				field.Set(reflect.ValueOf(make(map[string]interface{})))
+			}
+			for key, option := range options {
+				field.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(option))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,105 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package age
+
+import (
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"github.com/root-gg/plik/client/crypto/passphrase"
+)
+
+// Backend object
+type Backend struct {
+	Config *BackendConfig
+}
+
+// NewAgeBackend instantiate a new age Crypto Backend
+// and configure it from config map
+func NewAgeBackend(config map[string]interface{}) (ab *Backend) {
+	ab = new(Backend)
+	ab.Config = NewAgeBackendConfig(config)
+	return
+}
+
+// Configure implementation for age Crypto Backend
+func (ab *Backend) Configure(arguments map[string]interface{}) (err error) {
+	ab.Config.Passphrase, err = passphrase.FromArguments(arguments)
+	return
+}
+
+// Encrypt implementation for age Crypto Backend
+// Files are encrypted with a passphrase derived key ( scrypt )
+func (ab *Backend) Encrypt(reader io.Reader, writer io.Writer) (err error) {
+	recipient, err := age.NewScryptRecipient(ab.Config.Passphrase)
+	if err != nil {
+		return fmt.Errorf("Invalid passphrase : %s", err)
+	}
+	recipient.SetWorkFactor(ab.Config.WorkFactor)
+
+	encryptWriter, err := age.Encrypt(writer, recipient)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt : %s", err)
+	}
+
+	_, err = io.Copy(encryptWriter, reader)
+	if err != nil {
+		return
+	}
+	return encryptWriter.Close()
+}
+
+// Decrypt implementation for age Crypto Backend
+func (ab *Backend) Decrypt(reader io.Reader, writer io.Writer) (err error) {
+	identity, err := age.NewScryptIdentity(ab.Config.Passphrase)
+	if err != nil {
+		return fmt.Errorf("Invalid passphrase : %s", err)
+	}
+
+	decryptReader, err := age.Decrypt(reader, identity)
+	if err != nil {
+		return fmt.Errorf("Unable to decrypt : %s", err)
+	}
+
+	_, err = io.Copy(writer, decryptReader)
+	return
+}
+
+// Comments implementation for age Crypto Backend
+// age prompts for the passphrase
+func (ab *Backend) Comments() string {
+	return "age -d"
+}
+
+// GetConfiguration implementation for age Crypto Backend
+func (ab *Backend) GetConfiguration() interface{} {
+	return ab.Config
+}
@@ -33,6 +33,8 @@ import (
 	"errors"
 	"io"
 
+	"github.com/root-gg/plik/client/crypto/age"
+	"github.com/root-gg/plik/client/crypto/e2e"
 	"github.com/root-gg/plik/client/crypto/openssl"
 	"github.com/root-gg/plik/client/crypto/pgp"
 )
@@ -42,6 +44,7 @@ import (
 type Backend interface {
 	Configure(arguments map[string]interface{}) (err error)
 	Encrypt(reader io.Reader, writer io.Writer) (err error)
+	Decrypt(reader io.Reader, writer io.Writer) (err error)
 	Comments() string
 	GetConfiguration() interface{}
 }
@@ -50,6 +53,10 @@ type Backend interface {
 // We are passing its configuration found in .plikrc file or arguments
 func NewCryptoBackend(name string, config map[string]interface{}) (backend Backend, err error) {
 	switch name {
+	case "age":
+		backend = age.NewAgeBackend(config)
+	case "e2e":
+		backend = e2e.NewE2EBackend(config)
 	case "openssl":
 		backend = openssl.NewOpenSSLBackend(config)
 	case "pgp":
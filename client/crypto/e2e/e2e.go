@@ -0,0 +1,256 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package e2e
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// End-to-end encrypted uploads use a random key which is only given in
+// the fragment of the links so it is never sent to the server. File
+// contents, names and upload comments are encrypted with AES-256-GCM.
+//
+// Encrypted files start with a random salt used to derive the key of the
+// file. The content is then split in chunks sealed with a nonce made of
+// the chunk counter and a flag set for the last chunk, so truncated or
+// reordered files can't be decrypted.
+
+const (
+	keySize   = 32
+	saltSize  = 16
+	chunkSize = 64 * 1024
+)
+
+// Backend object
+type Backend struct {
+	Config *BackendConfig
+	key    []byte
+}
+
+// NewE2EBackend instantiate a new end-to-end encryption Crypto Backend
+// and configure it from config map
+func NewE2EBackend(config map[string]interface{}) (eb *Backend) {
+	eb = new(Backend)
+	eb.Config = NewE2EBackendConfig(config)
+	return
+}
+
+// Configure implementation for end-to-end encryption Crypto Backend
+// A new key is generated unless one is given with --key
+func (eb *Backend) Configure(arguments map[string]interface{}) (err error) {
+	if arguments["--key"] != nil && arguments["--key"].(string) != "" {
+		eb.Config.Key = arguments["--key"].(string)
+	}
+
+	if eb.Config.Key == "" {
+		eb.key = make([]byte, keySize)
+		if _, err = rand.Read(eb.key); err != nil {
+			return fmt.Errorf("Unable to generate key : %s", err)
+		}
+		eb.Config.Key = base64.RawURLEncoding.EncodeToString(eb.key)
+		return
+	}
+
+	eb.key, err = base64.RawURLEncoding.DecodeString(eb.Config.Key)
+	if err != nil || len(eb.key) != keySize {
+		return errors.New("Invalid end-to-end encryption key")
+	}
+	return nil
+}
+
+// Encrypt implementation for end-to-end encryption Crypto Backend
+func (eb *Backend) Encrypt(reader io.Reader, writer io.Writer) (err error) {
+	salt := make([]byte, saltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	aead, err := eb.newAEAD(salt, "plik-e2e content")
+	if err != nil {
+		return
+	}
+	if _, err = writer.Write(salt); err != nil {
+		return
+	}
+
+	bufferedReader := bufio.NewReader(reader)
+	buffer := make([]byte, chunkSize)
+	var counter uint64
+	for {
+		var n int
+		n, err = io.ReadFull(bufferedReader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return
+		}
+
+		// A full chunk is the last one only if nothing follows
+		last := err != nil
+		if !last {
+			if _, err = bufferedReader.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return
+			}
+		}
+
+		if _, err = writer.Write(aead.Seal(nil, nonce(counter, last), buffer[:n], nil)); err != nil {
+			return
+		}
+		if last {
+			return nil
+		}
+		counter++
+	}
+}
+
+// Decrypt implementation for end-to-end encryption Crypto Backend
+func (eb *Backend) Decrypt(reader io.Reader, writer io.Writer) (err error) {
+	salt := make([]byte, saltSize)
+	if _, err = io.ReadFull(reader, salt); err != nil {
+		return fmt.Errorf("Unable to decrypt : %s", err)
+	}
+	aead, err := eb.newAEAD(salt, "plik-e2e content")
+	if err != nil {
+		return
+	}
+
+	bufferedReader := bufio.NewReader(reader)
+	buffer := make([]byte, chunkSize+aead.Overhead())
+	var counter uint64
+	for {
+		var n int
+		n, err = io.ReadFull(bufferedReader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return
+		}
+
+		last := err != nil
+		if !last {
+			if _, err = bufferedReader.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return
+			}
+		}
+
+		var plaintext []byte
+		plaintext, err = aead.Open(buffer[:0], nonce(counter, last), buffer[:n], nil)
+		if err != nil {
+			return errors.New("Unable to decrypt : invalid key or corrupted file")
+		}
+		if _, err = writer.Write(plaintext); err != nil {
+			return
+		}
+		if last {
+			return nil
+		}
+		counter++
+	}
+}
+
+// EncryptString encrypts file names and comments
+func (eb *Backend) EncryptString(plaintext string) (ciphertext string, err error) {
+	aead, err := eb.newAEAD(nil, "plik-e2e metadata")
+	if err != nil {
+		return
+	}
+
+	sealed := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(sealed); err != nil {
+		return
+	}
+	sealed = aead.Seal(sealed, sealed, []byte(plaintext), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptString decrypts file names and comments
+func (eb *Backend) DecryptString(ciphertext string) (plaintext string, err error) {
+	aead, err := eb.newAEAD(nil, "plik-e2e metadata")
+	if err != nil {
+		return
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("Unable to decrypt : invalid encrypted string")
+	}
+	opened, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Unable to decrypt : invalid key or corrupted string")
+	}
+	return string(opened), nil
+}
+
+// Comments implementation for end-to-end encryption Crypto Backend
+// Files can only be decrypted by the plik client
+func (eb *Backend) Comments() string {
+	return ""
+}
+
+// GetConfiguration implementation for end-to-end encryption Crypto Backend
+func (eb *Backend) GetConfiguration() interface{} {
+	return eb.Config
+}
+
+func (eb *Backend) newAEAD(salt []byte, info string) (aead cipher.AEAD, err error) {
+	if len(eb.key) != keySize {
+		return nil, errors.New("Missing end-to-end encryption key")
+	}
+
+	key := make([]byte, keySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, eb.key, salt, []byte(info)), key); err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
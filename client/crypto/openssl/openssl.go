@@ -30,12 +30,13 @@ THE SOFTWARE.
 package openssl
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 
-	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/client/crypto/passphrase"
 )
 
 // Backend object
@@ -59,18 +60,14 @@ func (ob *Backend) Configure(arguments map[string]interface{}) (err error) {
 	if arguments["--cipher"] != nil && arguments["--cipher"].(string) != "" {
 		ob.Config.Cipher = arguments["--cipher"].(string)
 	}
-	if arguments["--passphrase"] != nil && arguments["--passphrase"].(string) != "" {
-		ob.Config.Passphrase = arguments["--passphrase"].(string)
-		if ob.Config.Passphrase == "-" {
-			fmt.Printf("Please enter a passphrase : ")
-			_, err = fmt.Scanln(&ob.Config.Passphrase)
-			if err != nil {
-				return err
-			}
+	if _, err = os.Stat(ob.Config.Openssl); err != nil {
+		if ob.Config.Openssl, err = exec.LookPath("openssl"); err != nil {
+			return errors.New("openssl binary not found in $PATH, please install it or use the age backend ( --secure age )")
 		}
-	} else {
-		ob.Config.Passphrase = common.GenerateRandomID(25)
-		fmt.Println("Passphrase : " + ob.Config.Passphrase)
+	}
+	ob.Config.Passphrase, err = passphrase.FromArguments(arguments)
+	if err != nil {
+		return
 	}
 	if arguments["--secure-options"] != nil && arguments["--secure-options"].(string) != "" {
 		ob.Config.Options = arguments["--secure-options"].(string)
@@ -83,38 +80,50 @@ func (ob *Backend) Configure(arguments map[string]interface{}) (err error) {
 func (ob *Backend) Encrypt(reader io.Reader, writer io.Writer) (err error) {
 	passReader, passWriter, err := os.Pipe()
 	if err != nil {
-		fmt.Printf("Unable to make pipe : %s\n", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("Unable to make pipe : %s", err)
 	}
 	_, err = passWriter.Write([]byte(ob.Config.Passphrase))
 	if err != nil {
-		fmt.Printf("Unable to write to pipe : %s\n", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("Unable to write to pipe : %s", err)
 	}
 	err = passWriter.Close()
 	if err != nil {
-		fmt.Printf("Unable to close to pipe : %s\n", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("Unable to close to pipe : %s", err)
 	}
 	cmd := exec.Command(ob.Config.Openssl, ob.Config.Cipher, "-pass", fmt.Sprintf("fd:3"))
 	cmd.Stdin = reader                                  // fd:0
 	cmd.Stdout = writer                                 // fd:1
 	cmd.Stderr = os.Stderr                              // fd:2
 	cmd.ExtraFiles = append(cmd.ExtraFiles, passReader) // fd:3
-	err = cmd.Start()
+	err = cmd.Run()
 	if err != nil {
-		fmt.Printf("Unable to run openssl cmd : %s\n", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("Unable to run openssl cmd : %s", err)
 	}
-	err = cmd.Wait()
+	return
+}
+
+// Decrypt implementation for OpenSSL Crypto Backend
+func (ob *Backend) Decrypt(reader io.Reader, writer io.Writer) (err error) {
+	passReader, passWriter, err := os.Pipe()
 	if err != nil {
-		fmt.Printf("Unable to run openssl cmd : %s\n", err)
-		os.Exit(1)
-		return
+		return fmt.Errorf("Unable to make pipe : %s", err)
+	}
+	_, err = passWriter.Write([]byte(ob.Config.Passphrase))
+	if err != nil {
+		return fmt.Errorf("Unable to write to pipe : %s", err)
+	}
+	err = passWriter.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to close to pipe : %s", err)
+	}
+	cmd := exec.Command(ob.Config.Openssl, ob.Config.Cipher, "-d", "-pass", fmt.Sprintf("fd:3"))
+	cmd.Stdin = reader                                  // fd:0
+	cmd.Stdout = writer                                 // fd:1
+	cmd.Stderr = os.Stderr                              // fd:2
+	cmd.ExtraFiles = append(cmd.ExtraFiles, passReader) // fd:3
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Unable to run openssl cmd : %s", err)
 	}
 	return
 }
@@ -0,0 +1,58 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package passphrase
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/root-gg/plik/client/prompt"
+	"github.com/root-gg/plik/server/common"
+)
+
+// FromArguments returns the passphrase given with --passphrase. The
+// user is prompted for it if it is '-' and a random passphrase is
+// generated when there is none.
+func FromArguments(arguments map[string]interface{}) (passphrase string, err error) {
+	if arguments["--passphrase"] == nil || arguments["--passphrase"].(string) == "" {
+		passphrase = common.GenerateRandomID(25)
+		fmt.Fprintln(os.Stderr, "Passphrase : "+passphrase)
+		return
+	}
+
+	passphrase = arguments["--passphrase"].(string)
+	if passphrase == "-" {
+		err = prompt.Ask("Please enter a passphrase : ", &passphrase)
+		if err != nil {
+			return "", err
+		}
+	}
+	return
+}
@@ -38,11 +38,13 @@ import (
 
 // BackendConfig object
 type BackendConfig struct {
-	Gpg       string
-	Keyring   string
-	Recipient string
-	Email     string
-	Entity    *openpgp.Entity
+	Gpg        string
+	Keyring    string
+	Recipient  string
+	Email      string
+	Entity     *openpgp.Entity
+	Symmetric  bool
+	Passphrase string
 }
 
 // NewPgpBackendConfig instantiate a new Backend Configuration
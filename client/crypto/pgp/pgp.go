@@ -34,10 +34,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"strings"
 
+	"github.com/root-gg/plik/client/crypto/passphrase"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 // Backend object
@@ -61,8 +64,11 @@ func (pb *Backend) Configure(arguments map[string]interface{}) (err error) {
 		pb.Config.Recipient = arguments["--recipient"].(string)
 	}
 
-	if pb.Config.Recipient == "" {
-		return errors.New("No PGP recipient specified (--recipient Bob or Recipient param in section [SecureOptions] of .plikrc)")
+	// Without recipient files are encrypted with a passphrase
+	if pb.Config.Recipient == "" || arguments["--passphrase"] != nil {
+		pb.Config.Symmetric = true
+		pb.Config.Passphrase, err = passphrase.FromArguments(arguments)
+		return
 	}
 
 	// Keyring is here ?
@@ -123,22 +129,98 @@ func (pb *Backend) Configure(arguments map[string]interface{}) (err error) {
 
 // Encrypt implementation for PGP Crypto Backend
 func (pb *Backend) Encrypt(reader io.Reader, writer io.Writer) (err error) {
-	w, _ := armor.Encode(writer, "PGP MESSAGE", nil)
-	plaintext, _ := openpgp.Encrypt(w, []*openpgp.Entity{pb.Config.Entity}, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	armorWriter, err := armor.Encode(writer, "PGP MESSAGE", nil)
+	if err != nil {
+		return
+	}
+
+	hints := &openpgp.FileHints{IsBinary: true}
+	var plaintext io.WriteCloser
+	if pb.Config.Symmetric {
+		config := &packet.Config{DefaultCipher: packet.CipherAES256}
+		plaintext, err = openpgp.SymmetricallyEncrypt(armorWriter, []byte(pb.Config.Passphrase), hints, config)
+	} else {
+		plaintext, err = openpgp.Encrypt(armorWriter, []*openpgp.Entity{pb.Config.Entity}, nil, hints, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt : %s", err)
+	}
 
 	_, err = io.Copy(plaintext, reader)
 	if err != nil {
-		return (err)
+		return
 	}
 
-	plaintext.Close()
-	w.Close()
+	if err = plaintext.Close(); err != nil {
+		return
+	}
+	return armorWriter.Close()
+}
 
+// Decrypt implementation for PGP Crypto Backend
+// Files encrypted for a recipient are decrypted by gpg
+// which handles private keys and may prompt for their passphrase
+func (pb *Backend) Decrypt(reader io.Reader, writer io.Writer) (err error) {
+	if pb.Config.Symmetric {
+		return pb.decryptSymmetric(reader, writer)
+	}
+
+	gpg := pb.Config.Gpg
+	if _, err = os.Stat(gpg); err != nil {
+		if gpg, err = exec.LookPath("gpg"); err != nil {
+			return errors.New("gpg binary not found in $PATH, please install or edit ~/.plickrc")
+		}
+	}
+
+	cmd := exec.Command(gpg, "--decrypt", "--quiet")
+	cmd.Stdin = reader
+	cmd.Stdout = writer
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Unable to run gpg cmd : %s", err)
+	}
+	return
+}
+
+func (pb *Backend) decryptSymmetric(reader io.Reader, writer io.Writer) (err error) {
+	block, err := armor.Decode(reader)
+	if err != nil {
+		return fmt.Errorf("Unable to read PGP message : %s", err)
+	}
+
+	// The prompt is called again when the passphrase is wrong
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if tried {
+			return nil, errors.New("Invalid passphrase")
+		}
+		tried = true
+		return []byte(pb.Config.Passphrase), nil
+	}
+
+	message, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{}, prompt, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to decrypt : %s", err)
+	}
+
+	_, err = io.Copy(writer, message.UnverifiedBody)
+	if err != nil {
+		return
+	}
+
+	// The integrity of the message is checked once it has been fully read
+	if message.SignatureError != nil {
+		return fmt.Errorf("Unable to decrypt : %s", message.SignatureError)
+	}
 	return
 }
 
 // Comments implementation for PGP Crypto Backend
 func (pb *Backend) Comments() string {
+	if pb.Config.Symmetric {
+		return fmt.Sprintf("gpg -d --batch --passphrase %s", pb.Config.Passphrase)
+	}
 	return "gpg -d"
 }
 
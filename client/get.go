@@ -0,0 +1,447 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cheggaaa/pb"
+	"github.com/root-gg/plik/client/archive/walk"
+	"github.com/root-gg/plik/client/config"
+	"github.com/root-gg/plik/client/plik"
+	"github.com/root-gg/plik/client/prompt"
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/digest"
+	"github.com/root-gg/utils"
+)
+
+// get downloads the files of an upload. Files are first saved as .part
+// files so interrupted downloads can be resumed, then their digest is
+// checked and they are decrypted/extracted if needed.
+func get(arguments map[string]interface{}) (err error) {
+	serverURL, uploadID, fileID, key, err := parseUploadURL(arguments["URL"].(string))
+	if err != nil {
+		return
+	}
+	if key != "" && arguments["--key"] == nil {
+		arguments["--key"] = key
+	}
+
+	err = setCredentials(arguments)
+	if err != nil {
+		return
+	}
+
+	client := newClient(serverURL)
+	uploadInfo, err := getProtectedUpload(client, uploadID)
+	if err != nil {
+		return fmt.Errorf("Unable to get upload %s : %s", uploadID, err)
+	}
+
+	err = config.ConfigureRestore(uploadInfo.Comments, arguments)
+	if err != nil {
+		return
+	}
+
+	// Names of end-to-end encrypted files are encrypted too
+	if e2eBackend := config.GetE2EBackend(); e2eBackend != nil {
+		for _, file := range uploadInfo.Files {
+			var name string
+			name, err = e2eBackend.DecryptString(file.Name)
+			if err != nil {
+				return fmt.Errorf("Unable to decrypt file name : %s", err)
+			}
+			remoteNames[file.ID] = file.Name
+			file.Name = name
+			if strings.Contains(name, "/") {
+				file.Name, file.Path = path.Base(name), name
+			}
+		}
+	}
+
+	// Select files to download
+	var files []*common.File
+	for _, file := range uploadInfo.Files {
+		if file.Status != "uploaded" {
+			continue
+		}
+		if fileID != "" && file.ID != fileID {
+			continue
+		}
+		if names, ok := arguments["FILE"].([]string); ok && len(names) > 0 && !matchFile(file, names) {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Sort(filesByName(files))
+
+	printf("Files\n\n")
+	for _, file := range files {
+		printf("    %s (%s)\n", file.FullPath(), utils.BytesToString(int(file.CurrentSize)))
+	}
+	printf("\n")
+
+	if arguments["--list"].(bool) {
+		if config.Config.Quiet {
+			for _, file := range files {
+				fmt.Println(client.FileURL(uploadInfo, remoteFile(file)) + keyFragment())
+			}
+		}
+		return
+	}
+
+	directory := "."
+	if arguments["--output"] != nil && arguments["--output"].(string) != "" {
+		directory = arguments["--output"].(string)
+		if err = os.MkdirAll(directory, 0777); err != nil {
+			return fmt.Errorf("Unable to create directory %s : %s", directory, err)
+		}
+	}
+
+	// Each file needs its own yubikey OTP so files are downloaded one by one
+	if uploadInfo.ProtectedByYubikey {
+		for _, file := range files {
+			var otp string
+			if err = prompt.Ask(fmt.Sprintf("Yubikey token for %s : ", file.FullPath()), &otp); err != nil {
+				return fmt.Errorf("Unable to get yubikey token : %s", err)
+			}
+			if err = download(client, uploadInfo, file, otp, directory); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	// Directory trees may have a lot of files
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failures := 0
+	slots := make(chan struct{}, config.Config.Parallelism)
+	for _, file := range files {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(file *common.File) {
+			defer func() { <-slots }()
+			defer wg.Done()
+			if e := download(client, uploadInfo, file, "", directory); e != nil {
+				mutex.Lock()
+				fmt.Fprintf(os.Stderr, "Unable to download %s : %s\n", file.FullPath(), e)
+				failures++
+				mutex.Unlock()
+			}
+		}(file)
+	}
+	wg.Wait()
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d file(s) failed to download", failures, len(files))
+	}
+	return
+}
+
+// Credentials of password protected uploads
+var login, password string
+
+// setCredentials sets the credentials of password protected
+// uploads given on the command line or asks for them with -p
+func setCredentials(arguments map[string]interface{}) (err error) {
+	if arguments["--password"] != nil && arguments["--password"].(string) != "" {
+		login, password = "plik", arguments["--password"].(string)
+		if i := strings.Index(password, ":"); i >= 0 {
+			login, password = password[:i], password[i+1:]
+		}
+	}
+
+	if arguments["-p"].(bool) && password == "" {
+		err = askCredentials()
+	}
+	return
+}
+
+// getProtectedUpload gets the upload and asks for
+// credentials if the upload is password protected
+func getProtectedUpload(client *plik.Client, uploadID string) (uploadInfo *common.Upload, err error) {
+	uploadInfo, err = client.GetUpload(&common.Upload{ID: uploadID, Login: login, Password: password})
+	if plik.IsUnauthorized(err) && password == "" {
+		err = askCredentials()
+		if err != nil {
+			return
+		}
+		uploadInfo, err = client.GetUpload(&common.Upload{ID: uploadID, Login: login, Password: password})
+	}
+	if err != nil {
+		return
+	}
+	config.Debug("Got upload info : " + config.Sdump(uploadInfo))
+	return
+}
+
+// Names of end-to-end encrypted files on the server by file id
+var remoteNames = make(map[string]string)
+
+// parseUploadURL accepts the web interface url of an upload
+// ( http://plik/#/?id=ID ), the url of a file
+// ( http://plik/file/ID/FILEID/NAME ) or a bare upload id.
+// Links of end-to-end encrypted uploads also have the key
+// ( http://plik/#/?id=ID&key=KEY or http://plik/file/ID/FILEID/NAME#key=KEY )
+func parseUploadURL(rawURL string) (serverURL string, uploadID string, fileID string, key string, err error) {
+	if !strings.Contains(rawURL, "/") {
+		return config.Config.URL, rawURL, "", "", nil
+	}
+
+	if i := strings.Index(rawURL, "/#/"); i >= 0 {
+		serverURL = rawURL[:i]
+		var query url.Values
+		fragment := rawURL[i+3:]
+		if j := strings.Index(fragment, "?"); j >= 0 {
+			query, err = url.ParseQuery(fragment[j+1:])
+			if err != nil {
+				return "", "", "", "", fmt.Errorf("Invalid upload url %s : %s", rawURL, err)
+			}
+		}
+		uploadID = query.Get("id")
+		key = query.Get("key")
+	} else if i := strings.Index(rawURL, "/file/"); i >= 0 {
+		serverURL = rawURL[:i]
+		path := rawURL[i+len("/file/"):]
+		if j := strings.Index(path, "#"); j >= 0 {
+			var query url.Values
+			query, err = url.ParseQuery(path[j+1:])
+			if err != nil {
+				return "", "", "", "", fmt.Errorf("Invalid file url %s : %s", rawURL, err)
+			}
+			key = query.Get("key")
+			path = path[:j]
+		}
+		parts := strings.Split(path, "/")
+		uploadID = parts[0]
+		if len(parts) > 1 {
+			fileID = parts[1]
+		}
+	}
+
+	if uploadID == "" {
+		return "", "", "", "", fmt.Errorf("Invalid upload url %s", rawURL)
+	}
+	return
+}
+
+func askCredentials() (err error) {
+	err = prompt.Ask("Login [plik]: ", &login)
+	if err != nil && err.Error() != "unexpected newline" {
+		return fmt.Errorf("Unable to get login : %s", err)
+	}
+	if login == "" {
+		login = "plik"
+	}
+	err = prompt.Ask("Password: ", &password)
+	if err != nil {
+		return fmt.Errorf("Unable to get password : %s", err)
+	}
+	return
+}
+
+// download saves the file in the directory. The file is restored
+// by the crypto and archive backends used to make the upload.
+func download(client *plik.Client, upload *common.Upload, file *common.File, otp string, directory string) (err error) {
+	path := filepath.Join(directory, filepath.Base(file.Name))
+	if file.Path != "" {
+		path, err = walk.Target(directory, file.Path)
+		if err != nil {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return
+		}
+	}
+	partPath := path + ".part"
+
+	// Resume the previous download
+	out, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	sha256Hash := sha256.New()
+	offset, err := io.Copy(sha256Hash, out)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Download(upload, remoteFile(file), &plik.DownloadParams{Offset: offset, Yubikey: otp})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		// Start over if the server sends the whole file
+		if offset > 0 {
+			offset = 0
+			sha256Hash.Reset()
+			if err = out.Truncate(0); err != nil {
+				return
+			}
+			if _, err = out.Seek(0, 0); err != nil {
+				return
+			}
+		}
+	case 416:
+		// The previous download was complete
+	}
+
+	if resp.StatusCode != 416 {
+		var writer io.Writer = io.MultiWriter(out, sha256Hash)
+		if !config.Config.Quiet {
+			bar := pb.New64(file.CurrentSize).SetUnits(pb.U_BYTES)
+			bar.Prefix(fmt.Sprintf("%-30s : ", file.FullPath()))
+			bar.ShowSpeed = true
+			bar.ShowFinalTime = false
+			bar.SetWidth(100)
+			bar.SetMaxWidth(100)
+			bar.Add64(offset)
+
+			writer = io.MultiWriter(writer, bar)
+			bar.Start()
+			defer bar.Finish()
+		}
+
+		_, err = io.Copy(writer, resp.Body)
+		if err != nil {
+			return fmt.Errorf("Download interrupted, run the same command again to resume : %s", err)
+		}
+	}
+
+	// Check the file digest before using the file
+	expected := file.Sha256
+	if expected == "" {
+		expected = digest.Parse(resp.Header.Get("Repr-Digest"))[digest.SHA256]
+	}
+	if expected != "" && expected != hex.EncodeToString(sha256Hash.Sum(nil)) {
+		out.Close()
+		os.Remove(partPath)
+		return fmt.Errorf("Digest mismatch : %s got corrupted during the download", file.Name)
+	}
+
+	if config.GetCryptoBackend() == nil && config.GetArchiveBackend() == nil {
+		out.Close()
+		return os.Rename(partPath, path)
+	}
+
+	// Decrypt and extract the downloaded data
+	if _, err = out.Seek(0, 0); err != nil {
+		return
+	}
+	err = restore(out, path, directory)
+	if err != nil {
+		return
+	}
+
+	out.Close()
+	return os.Remove(partPath)
+}
+
+func restore(reader io.Reader, path string, directory string) (err error) {
+	if cryptoBackend := config.GetCryptoBackend(); cryptoBackend != nil {
+		encrypted := reader
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			pipeWriter.CloseWithError(cryptoBackend.Decrypt(encrypted, pipeWriter))
+		}()
+		defer pipeReader.Close()
+		reader = pipeReader
+	}
+
+	if archiveBackend := config.GetArchiveBackend(); archiveBackend != nil {
+		return archiveBackend.Extract(reader, directory)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, reader)
+	if err != nil {
+		os.Remove(path)
+	}
+	return
+}
+
+// remoteFile returns the file with its name on the server
+func remoteFile(file *common.File) *common.File {
+	remoteName, ok := remoteNames[file.ID]
+	if !ok {
+		return file
+	}
+	remote := *file
+	remote.Name = remoteName
+	return &remote
+}
+
+func keyFragment() string {
+	if e2eBackend := config.GetE2EBackend(); e2eBackend != nil {
+		return "#key=" + e2eBackend.Config.Key
+	}
+	return ""
+}
+
+// matchFile tells if the file is one of the files asked on the command
+// line by name, by id, by path or by the path of one of its directories
+func matchFile(file *common.File, names []string) bool {
+	for _, name := range names {
+		if name == file.Name || name == file.ID || name == file.FullPath() {
+			return true
+		}
+		if strings.HasPrefix(file.FullPath(), strings.TrimSuffix(name, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type filesByName []*common.File
+
+func (files filesByName) Len() int           { return len(files) }
+func (files filesByName) Swap(i, j int)      { files[i], files[j] = files[j], files[i] }
+func (files filesByName) Less(i, j int) bool { return files[i].FullPath() < files[j].FullPath() }
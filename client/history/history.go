@@ -0,0 +1,141 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is an upload made with the client. The upload token
+// is kept to be able to delete the upload or extend its ttl.
+type Entry struct {
+	Server              string  `json:"server"`
+	ID                  string  `json:"id"`
+	URL                 string  `json:"url"`
+	UploadToken         string  `json:"uploadToken"`
+	Creation            int64   `json:"creation"`
+	Expire              int64   `json:"expire"`
+	ProtectedByPassword bool    `json:"protectedByPassword,omitempty"`
+	Files               []*File `json:"files"`
+}
+
+// File is a file of an upload of the history
+type File struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+// Expired tells if the upload has been removed from the server.
+// Uploads without expiration have an Expire date of 0.
+func (entry *Entry) Expired() bool {
+	return entry.Expire > 0 && time.Now().Unix() >= entry.Expire
+}
+
+// ExpiresWithin tells if the upload will be removed from the server
+// in less than this duration
+func (entry *Entry) ExpiresWithin(duration time.Duration) bool {
+	return entry.Expire > 0 && time.Now().Add(duration).Unix() >= entry.Expire
+}
+
+// Load returns the uploads of the history file. Expired uploads
+// are removed from the history.
+func Load(file string) (entries []*Entry, err error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var all []*Entry
+	err = json.Unmarshal(content, &all)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range all {
+		if !entry.Expired() {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != len(all) {
+		err = Save(file, entries)
+	}
+	return
+}
+
+// Save replaces the history file. It is only readable by the
+// user as it has the tokens of the uploads.
+func Save(file string, entries []*Entry) (err error) {
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(file+".tmp", content, 0600)
+	if err != nil {
+		return
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// Add appends an upload to the history file
+func Add(file string, entry *Entry) (err error) {
+	entries, err := Load(file)
+	if err != nil {
+		return
+	}
+	return Save(file, append(entries, entry))
+}
+
+// Find returns the upload of the history with this id
+func Find(entries []*Entry, id string) *Entry {
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
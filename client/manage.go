@@ -0,0 +1,341 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/root-gg/plik/client/config"
+	"github.com/root-gg/plik/client/history"
+	"github.com/root-gg/plik/client/plik"
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/utils"
+)
+
+// addToHistory saves the upload and its token in the history
+func addToHistory(upload *common.Upload) (err error) {
+	output, err := newUploadOutput(upload)
+	if err != nil {
+		return
+	}
+
+	entry := &history.Entry{
+		Server:              config.Config.URL,
+		ID:                  upload.ID,
+		URL:                 output.URL,
+		UploadToken:         upload.UploadToken,
+		Creation:            upload.Creation,
+		Expire:              expireDate(upload),
+		ProtectedByPassword: upload.ProtectedByPassword,
+	}
+	for _, file := range output.Files {
+		entry.Files = append(entry.Files, &history.File{
+			ID:   file.ID,
+			Name: file.Name,
+			Path: file.Path,
+			Size: file.Size,
+			URL:  file.URL,
+		})
+	}
+
+	return history.Add(config.HistoryFile(), entry)
+}
+
+// list prints the uploads of the history which are not expired
+func list(arguments map[string]interface{}) (err error) {
+	entries, err := history.Load(config.HistoryFile())
+	if err != nil {
+		return fmt.Errorf("Unable to load history : %s", err)
+	}
+
+	if config.Config.JSON {
+		if entries == nil {
+			entries = []*history.Entry{}
+		}
+		return printDocument(entries)
+	}
+
+	for _, entry := range entries {
+		if config.Config.Quiet {
+			fmt.Println(entry.URL)
+			continue
+		}
+
+		fmt.Printf("%s  expires %s  %s\n", formatDate(entry.Creation), formatDate(entry.Expire), entry.URL)
+		if entry.ExpiresWithin(24 * time.Hour) {
+			fmt.Printf("    Expires soon, keep it longer with : plik extend --ttl 7d %s\n", entry.URL)
+		}
+		for _, file := range entry.Files {
+			name := file.Name
+			if file.Path != "" {
+				name = file.Path
+			}
+			fmt.Printf("    %s (%s)\n", name, utils.BytesToString(int(file.Size)))
+		}
+	}
+	return
+}
+
+// info prints the upload as the server knows it
+func info(arguments map[string]interface{}) (err error) {
+	serverURL, uploadID, entries, entry, err := lookupUpload(arguments)
+	if err != nil {
+		return
+	}
+
+	err = setCredentials(arguments)
+	if err != nil {
+		return
+	}
+
+	uploadInfo, err := getProtectedUpload(newClient(serverURL), uploadID)
+	if err != nil {
+		// Forget uploads removed from the server
+		if entry != nil && plik.IsNotFound(err) {
+			saveHistory(entries, entry, true)
+		}
+		return fmt.Errorf("Unable to get upload %s : %s", uploadID, err)
+	}
+
+	if config.Config.JSON {
+		return printDocument(uploadInfo)
+	}
+
+	// Names of end-to-end encrypted files are only known by the history
+	names := make(map[string]string)
+	uploadURL := fmt.Sprintf("%s/#/?id=%s", serverURL, uploadInfo.ID)
+	if entry != nil {
+		uploadURL = entry.URL
+		for _, file := range entry.Files {
+			names[file.ID] = file.Name
+			if file.Path != "" {
+				names[file.ID] = file.Path
+			}
+		}
+	}
+
+	fmt.Printf("Upload %s\n\n", uploadURL)
+	fmt.Printf("    Created      : %s\n", formatDate(uploadInfo.Creation))
+	fmt.Printf("    Expires      : %s\n", formatDate(expireDate(uploadInfo)))
+	var options []string
+	if uploadInfo.OneShot {
+		options = append(options, "oneshot")
+	}
+	if uploadInfo.Removable {
+		options = append(options, "removable")
+	}
+	if uploadInfo.ProtectedByPassword {
+		options = append(options, "password")
+	}
+	if uploadInfo.ProtectedByYubikey {
+		options = append(options, "yubikey")
+	}
+	if len(options) > 0 {
+		fmt.Printf("    Options      : %s\n", strings.Join(options, ", "))
+	}
+	if entry != nil {
+		fmt.Printf("    Upload token : %s\n", entry.UploadToken)
+	}
+
+	fmt.Printf("\nFiles\n\n")
+	files := make([]*common.File, 0, len(uploadInfo.Files))
+	for _, file := range uploadInfo.Files {
+		if name, ok := names[file.ID]; ok {
+			file.Path = name
+		}
+		files = append(files, file)
+	}
+	sort.Sort(filesByName(files))
+	for _, file := range files {
+		fmt.Printf("    %s (%s) %s\n", file.FullPath(), utils.BytesToString(int(file.CurrentSize)), file.Status)
+	}
+	return
+}
+
+// remove deletes an upload of the history from the server
+func remove(arguments map[string]interface{}) (err error) {
+	serverURL, uploadID, entries, entry, err := lookupUpload(arguments)
+	if err != nil {
+		return
+	}
+	if entry == nil {
+		return fmt.Errorf("Upload %s is not in the history, its upload token is unknown", uploadID)
+	}
+
+	err = setCredentials(arguments)
+	if err != nil {
+		return
+	}
+
+	upload, err := entryUpload(entry)
+	if err != nil {
+		return
+	}
+
+	err = newClient(serverURL).RemoveUpload(upload)
+	if err != nil && !plik.IsNotFound(err) {
+		return fmt.Errorf("Unable to delete upload %s : %s", uploadID, err)
+	}
+
+	if historyErr := saveHistory(entries, entry, true); historyErr != nil {
+		return fmt.Errorf("Unable to save history : %s", historyErr)
+	}
+	if err != nil {
+		return fmt.Errorf("Upload %s was already removed from the server", uploadID)
+	}
+
+	printf("Upload %s deleted\n", uploadID)
+	return
+}
+
+// extend changes the expiration date of an upload of the history
+func extend(arguments map[string]interface{}) (err error) {
+	serverURL, uploadID, entries, entry, err := lookupUpload(arguments)
+	if err != nil {
+		return
+	}
+	if entry == nil {
+		return fmt.Errorf("Upload %s is not in the history, its upload token is unknown", uploadID)
+	}
+	if arguments["--ttl"] == nil || arguments["--ttl"].(string) == "" {
+		return fmt.Errorf("Missing --ttl")
+	}
+
+	ttl, err := common.ParseTTL(arguments["--ttl"].(string))
+	if err != nil {
+		return
+	}
+
+	err = setCredentials(arguments)
+	if err != nil {
+		return
+	}
+
+	upload, err := entryUpload(entry)
+	if err != nil {
+		return
+	}
+
+	uploadInfo, err := newClient(serverURL).UpdateTTL(upload, ttl)
+	if err != nil {
+		return fmt.Errorf("Unable to extend upload %s : %s", uploadID, err)
+	}
+
+	entry.Expire = expireDate(uploadInfo)
+	if err = saveHistory(entries, entry, false); err != nil {
+		return fmt.Errorf("Unable to save history : %s", err)
+	}
+
+	printf("Upload %s expires %s\n", uploadID, formatDate(entry.Expire))
+	return
+}
+
+// lookupUpload returns the server and the id of the upload given on the
+// command line and the upload in the history if it has been made by plik
+func lookupUpload(arguments map[string]interface{}) (serverURL string, uploadID string, entries []*history.Entry, entry *history.Entry, err error) {
+	serverURL, uploadID, _, _, err = parseUploadURL(arguments["URL"].(string))
+	if err != nil {
+		return
+	}
+
+	entries, err = history.Load(config.HistoryFile())
+	if err != nil {
+		err = fmt.Errorf("Unable to load history : %s", err)
+		return
+	}
+
+	entry = history.Find(entries, uploadID)
+	if entry != nil {
+		serverURL = entry.Server
+	}
+	return
+}
+
+// saveHistory saves the updated or removed upload in the history
+func saveHistory(entries []*history.Entry, entry *history.Entry, removed bool) (err error) {
+	if removed {
+		var kept []*history.Entry
+		for _, e := range entries {
+			if e != entry {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	return history.Save(config.HistoryFile(), entries)
+}
+
+// entryUpload returns the upload of the history with the credentials
+// needed to manage it. Credentials are asked if the upload is protected.
+func entryUpload(entry *history.Entry) (upload *common.Upload, err error) {
+	if entry.ProtectedByPassword && password == "" {
+		err = askCredentials()
+		if err != nil {
+			return
+		}
+	}
+	upload = &common.Upload{ID: entry.ID, UploadToken: entry.UploadToken}
+	if entry.ProtectedByPassword {
+		upload.Login, upload.Password = login, password
+	}
+	return
+}
+
+// expireDate returns the date the upload will be removed at or 0.
+// Older servers don't return the expiration date.
+func expireDate(upload *common.Upload) int64 {
+	if upload.ExpireAt == nil {
+		upload.ExpireAt = upload.ExpirationDate()
+	}
+	if upload.ExpireAt == nil {
+		return 0
+	}
+	return upload.ExpireAt.Unix()
+}
+
+func formatDate(date int64) string {
+	if date == 0 {
+		return "never"
+	}
+	return time.Unix(date, 0).Format("2006-01-02 15:04")
+}
+
+// printDocument prints a JSON document on stdout
+func printDocument(document interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(document)
+}
@@ -30,19 +30,17 @@ THE SOFTWARE.
 package main
 
 import (
-	"bytes"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
-	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -50,6 +48,7 @@ import (
 	docopt "github.com/docopt/docopt-go"
 	"github.com/olekukonko/ts"
 	"github.com/root-gg/plik/client/config"
+	"github.com/root-gg/plik/client/plik"
 	"github.com/root-gg/plik/server/common"
 	"github.com/root-gg/utils"
 )
@@ -57,8 +56,7 @@ import (
 // Vars
 var arguments map[string]interface{}
 var transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-var client = http.Client{Transport: transport}
-var basicAuth string
+var client *plik.Client
 var err error
 
 // Main
@@ -67,67 +65,115 @@ func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	ts.GetSize()
 
-	// Load config
-	config.Load()
-
 	// Usage /!\ INDENT THIS WITH SPACES NOT TABS /!\
 	usage := `plik
 
 Usage:
+  plik get [options] URL [FILE] ...
+  plik list [options]
+  plik info [options] URL
+  plik delete [options] URL
+  plik extend [options] URL
   plik [options] [FILE] ...
 
 Options:
   -h --help                 Show this help
   -d --debug                Enable debug mode
   -q --quiet                Enable quiet mode
+  --json                    Print the upload, its files and their commands as a JSON document
   -v --version              Show plik version
+  --profile PROFILE         Use the server and the options of a profile of ~/.plikrc
+  --non-interactive         Never prompt, fail instead ( also set by PLIK_NON_INTERACTIVE=true )
   -o, --oneshot             Enable OneShot (Each file will be deleted on first download)
   -r, --removable           Enable Removable upload (Each file can be deleted by anyone at anymoment)
-  -t, --ttl TTL             Time before expiration (Upload will be removed in m|h|d)
+  -t, --ttl TTL             Time before expiration ( 30m, 12h, 2w, 1d12h ), also the new ttl of extend
   -n, --name NAME           Set file name when piping from STDIN
   --comments COMMENT        Set comments of the upload (MarkDown compatible)
-  --archive-options OPTIONS [tar|zip] Additional command line options
   -p                        Protect the upload with login and password
   --password PASSWD         Protect the upload with login:password ( if omitted default login is "plik" )
   -y, --yubikey             Protect the upload with a Yubikey OTP
   -a                        Archive upload using default archive params ( see ~/.plikrc )
   --archive MODE            Archive upload using specified archive backend : tar|zip
-  --compress MODE           [tar] Compression codec : gzip|bzip2|xz|lzip|lzma|lzop|compress|no
+  --compress MODE           [tar] Compression codec : gzip|xz|zstd|no ( bzip2 archives can only be extracted )
+  --include GLOBS           [tar|zip|recursive] Only upload the files matching one of the comma separated globs
+  --exclude GLOBS           [tar|zip|recursive] Do not upload the files matching one of the comma separated globs
+  -R, --recursive           Upload the files of the directories one by one keeping their path instead of archiving them
+  --symlinks POLICY         [recursive] Symbolic links to skip|follow|within ( only links to files of the directory ), default skip
   -s                        Encrypt upload usnig default encrypt params ( see ~/.plikrc )
-  --secure MODE             Archive upload using specified archive backend : openssl|pgp
+  --secure MODE             Encrypt upload using specified crypto backend : age|openssl|pgp
   --cipher CIPHER           [openssl] Openssl cipher to use ( see openssl help )
-  --passphrase PASSPHRASE   [openssl] Passphrase or '-' to be prompted for a passphrase
+  --passphrase PASSPHRASE   [age|openssl|pgp] Passphrase or '-' to be prompted for a passphrase
   --secure-options OPTIONS  [openssl|pgp] Additional command line options
-  --recipient RECIPIENT     [pgp] Set recipient for pgp backend ( example : --recipient Bob )
+  --parallel N              Number of files uploaded at the same time ( default 4 )
+  --retries N               Number of retries of failed uploads ( default 3 )
+  -e, --e2e                 End-to-end encrypt the files, their names and the comments ( the key is only in the printed links )
+  --key KEY                 [get] Key of an end-to-end encrypted upload ( taken from the link by default )
+  --recipient RECIPIENT     [pgp] Set recipient for pgp backend ( example : --recipient Bob ), without recipient a passphrase is used
+  --output DIR              [get] Save the downloaded files in DIR ( default is the current directory )
+  --list                    [get] Only list the files of the upload
 `
 	// Parse command line arguments
-	arguments, _ = docopt.Parse(usage, nil, true, "", false)
+	arguments, _ = docopt.Parse(usage, nil, true, "plik v"+common.GetVersion(), false)
+
+	// Load config
+	err = config.Load(arguments)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	client = newClient(config.Config.URL)
+
+	// Download files of an existing upload or manage the uploads of the history
+	commands := map[string]func(map[string]interface{}) error{
+		"get":    get,
+		"list":   list,
+		"info":   info,
+		"delete": remove,
+		"extend": extend,
+	}
+	for name, command := range commands {
+		if !arguments[name].(bool) {
+			continue
+		}
+		err = config.UnmarshalGetArgs(arguments)
+		if err == nil {
+			err = command(arguments)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Get the limits of the server to check the options before uploading
+	config.Server = getServerConfig()
 
 	// Unmarshal arguments in configuration
 	err = config.UnmarshalArgs(arguments)
 	if err != nil {
-		fmt.Printf("%s", err)
+		fmt.Fprintf(os.Stderr, "%s\n", strings.TrimSpace(err.Error()))
 		os.Exit(1)
 	}
 
 	// Creating upload on plik
 	config.Debug("Sending upload params : " + config.Sdump(config.Upload))
-	uploadInfo, err := createUpload(config.Upload)
+	uploadInfo, err := client.CreateUpload(config.Upload)
 	if err != nil {
-		printf("Unable to create upload : %s\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to create upload : %s\n", err)
 		os.Exit(1)
 	}
 	config.Debug("Got upload info : " + config.Sdump(uploadInfo))
 
 	printf("Upload successfully created : \n\n")
-	printf("    %s/#/?id=%s\n\n\n", config.Config.URL, uploadInfo.ID)
+	printf("    %s\n\n\n", getUploadURL(uploadInfo))
 
 	if config.Config.Archive {
 
 		pipeReader, pipeWriter := io.Pipe()
 		name, err := config.GetArchiveBackend().Archive(arguments["FILE"].([]string), pipeWriter)
 		if err != nil {
-			printf("Unable to archive files : %s\n", err)
+			fmt.Fprintf(os.Stderr, "Unable to archive files : %s\n", err)
 			os.Exit(1)
 		}
 
@@ -135,10 +181,10 @@ Options:
 			name = arguments["--name"].(string)
 		}
 
-		file, err := upload(uploadInfo, name, int64(0), pipeReader)
+		file, err := uploadStream(uploadInfo, name, pipeReader)
 		if err != nil {
-			printf("Unable to upload from STDIN : %s\n", err)
-			return
+			fmt.Fprintf(os.Stderr, "Unable to upload archive : %s\n", err)
+			os.Exit(1)
 		}
 		pipeReader.CloseWithError(err)
 
@@ -151,32 +197,37 @@ Options:
 				name = arguments["--name"].(string)
 			}
 
-			file, err := upload(uploadInfo, name, int64(0), os.Stdin)
+			file, err := uploadStream(uploadInfo, name, os.Stdin)
 			if err != nil {
-				printf("Unable to upload from STDIN : %s\n", err)
-				return
+				fmt.Fprintf(os.Stderr, "Unable to upload from STDIN : %s\n", err)
+				os.Exit(1)
 			}
 
 			uploadInfo.Files[file.ID] = file
 		} else {
 			// Upload individual files
-			var wg sync.WaitGroup
-			for _, fileToUpload := range config.Files {
-				wg.Add(1)
-				go func(fileToUpload *config.FileToUpload) {
-					defer wg.Done()
-
-					file, err := upload(uploadInfo, fileToUpload.Base, fileToUpload.Size, fileToUpload.FileHandle)
-					if err != nil {
-						printf("Unable upload file : %s\n", err)
-						return
-					}
+			err = uploadFiles(uploadInfo, config.Files)
+		}
+	}
 
-					uploadInfo.Files[file.ID] = file
-				}(fileToUpload)
-			}
-			wg.Wait()
+	// Keep the upload token to be able to manage the upload later
+	if config.Config.History {
+		if historyErr := addToHistory(uploadInfo); historyErr != nil {
+			fmt.Fprintf(os.Stderr, "Unable to save the upload in the history : %s\n", historyErr)
+		}
+	}
+
+	// Machine-readable output
+	if config.Config.JSON {
+		if jsonErr := printJSON(uploadInfo); jsonErr != nil {
+			fmt.Fprintf(os.Stderr, "Unable to print upload : %s\n", jsonErr)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
+		return
 	}
 
 	// Comments
@@ -199,138 +250,236 @@ Options:
 	// Upload files
 	printf("\nTotal\n\n")
 	printf("    %s (%d file(s)) \n\n", utils.BytesToString(int(totalSize)), len(uploadInfo.Files))
-}
 
-func createUpload(uploadParams *common.Upload) (upload *common.Upload, err error) {
-	var URL *url.URL
-	URL, err = url.Parse(config.Config.URL + "/upload")
 	if err != nil {
-		return
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
+}
 
-	var j []byte
-	j, err = json.Marshal(uploadParams)
+// newClient returns a client of the server sending the plik token
+func newClient(serverURL string) (c *plik.Client) {
+	c = plik.NewClient(serverURL)
+	c.HTTPClient = &http.Client{Transport: transport}
+	c.Token = config.Config.Token
+	c.ClientApp = "cli_client"
+	return
+}
+
+// getServerConfig returns the limits and the features of the server
+// and warns if the server and the client versions differ. Servers older
+// than the /config endpoint are not checked.
+func getServerConfig() (serverConfig *common.PublicConfiguration) {
+	serverConfig, err := client.GetConfig()
 	if err != nil {
-		return
+		config.Debug(fmt.Sprintf("Unable to get server configuration : %s", err))
+		return nil
 	}
+	config.Debug("Server configuration : " + config.Sdump(serverConfig))
 
-	var req *http.Request
-	req, err = http.NewRequest("POST", URL.String(), bytes.NewBuffer(j))
-	if err != nil {
-		return
+	// Development builds have no version
+	clientVersion := common.GetVersion()
+	if !config.Config.Quiet && serverConfig.Version != clientVersion && !strings.Contains(serverConfig.Version+clientVersion, "##VERSION##") {
+		fmt.Fprintf(os.Stderr, "Warning : plik client v%s may not be compatible with plik server v%s\n", clientVersion, serverConfig.Version)
 	}
+	return
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-ClientApp", "cli_client")
-	req.Header.Set("Referer", config.Config.URL)
+// uploadFiles uploads the files with a pool of workers. Failed
+// uploads are retried with an exponential backoff.
+func uploadFiles(uploadInfo *common.Upload, files []*config.FileToUpload) (err error) {
+	bars := make(map[*config.FileToUpload]*pb.ProgressBar)
+	var total *pb.ProgressBar
+	var pool *pb.Pool
+	if !config.Config.Quiet {
+		var totalSize int64
+		var poolBars []*pb.ProgressBar
+		for _, fileToUpload := range files {
+			bars[fileToUpload] = newProgressBar(uploadName(fileToUpload), fileToUpload.Size)
+			poolBars = append(poolBars, bars[fileToUpload])
+			totalSize += fileToUpload.Size
+		}
+		total = newProgressBar("Total", totalSize)
+		poolBars = append(poolBars, total)
 
-	var resp *http.Response
-	resp, err = client.Do(req)
-	if err != nil {
-		return
+		pool, err = pb.StartPool(poolBars...)
+		if err != nil {
+			return fmt.Errorf("Unable to display progress : %s", err)
+		}
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
+	var mutex sync.Mutex
+	var errs []string
+	var wg sync.WaitGroup
+	queue := make(chan *config.FileToUpload)
+	for i := 0; i < config.Config.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileToUpload := range queue {
+				file, err := uploadFile(uploadInfo, fileToUpload, bars[fileToUpload], total)
+
+				mutex.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("Unable to upload %s : %s", fileToUpload.Path, err))
+				} else {
+					uploadInfo.Files[file.ID] = file
+				}
+				mutex.Unlock()
+			}
+		}()
 	}
+	for _, fileToUpload := range files {
+		queue <- fileToUpload
+	}
+	close(queue)
+	wg.Wait()
 
-	basicAuth = resp.Header.Get("Authorization")
-
-	// Parse Json
-	upload = new(common.Upload)
-	err = json.Unmarshal(body, upload)
-	if err != nil {
-		return
+	if pool != nil {
+		pool.Stop()
 	}
 
+	for _, message := range errs {
+		fmt.Fprintln(os.Stderr, message)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d file(s) failed to upload", len(errs), len(files))
+	}
 	return
 }
 
-func upload(uploadInfo *common.Upload, name string, size int64, reader io.Reader) (file *common.File, err error) {
-	pipeReader, pipeWriter := io.Pipe()
-	multipartWriter := multipart.NewWriter(pipeWriter)
-
-	// TODO Handler error properly here
-	go func() error {
-		part, err := multipartWriter.CreateFormFile("file", name)
-		if err != nil {
-			fmt.Println(err)
-			return pipeWriter.CloseWithError(err)
-		}
-
-		var multiWriter io.Writer
-
-		if config.Config.Quiet {
-			multiWriter = part
-		} else {
-			bar := pb.New64(size).SetUnits(pb.U_BYTES)
-			bar.Prefix(fmt.Sprintf("%-"+strconv.Itoa(config.GetLongestFilename())+"s : ", name))
-			bar.ShowSpeed = true
-			bar.ShowFinalTime = false
-			bar.SetWidth(100)
-			bar.SetMaxWidth(100)
-
-			multiWriter = io.MultiWriter(part, bar)
-			bar.Start()
-			defer bar.Finish()
+// uploadFile uploads a file and retries if the upload fails
+func uploadFile(uploadInfo *common.Upload, fileToUpload *config.FileToUpload, bar *pb.ProgressBar, total *pb.ProgressBar) (file *common.File, err error) {
+	var progress func(n int)
+	if bar != nil {
+		progress = func(n int) {
+			bar.Add(n)
+			total.Add(n)
 		}
+	}
 
-		if config.Config.Secure {
-			err = config.GetCryptoBackend().Encrypt(reader, multiWriter)
-			if err != nil {
-				fmt.Println(err)
-				return pipeWriter.CloseWithError(err)
+	// Files are only opened when uploaded as directories may have a lot of them
+	fh, err := os.Open(fileToUpload.Path)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	defer fh.Close()
+
+	for attempt := 0; ; attempt++ {
+		file, err = upload(uploadInfo, uploadName(fileToUpload), fh, fileToUpload.Size, progress)
+		if err == nil {
+			if bar != nil {
+				bar.Postfix("")
+				bar.Finish()
 			}
-		} else {
-			_, err = io.Copy(multiWriter, reader)
-			if err != nil {
-				fmt.Println(err)
-				return pipeWriter.CloseWithError(err)
+			return
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= config.Config.Retries {
+			if bar != nil {
+				bar.Postfix(" failed")
+				bar.Finish()
 			}
+			return
 		}
 
-		err = multipartWriter.Close()
-		return pipeWriter.CloseWithError(err)
-	}()
-
-	var URL *url.URL
-	URL, err = url.Parse(config.Config.URL + "/upload/" + uploadInfo.ID + "/file")
-	if err != nil {
-		return
+		// Start over
+		config.Debug(fmt.Sprintf("Retrying upload of %s : %s", fileToUpload.Path, err))
+		if _, err = fh.Seek(0, 0); err != nil {
+			return
+		}
+		if bar != nil {
+			total.Add64(-bar.Get())
+			bar.Set64(0)
+			bar.Postfix(fmt.Sprintf(" retry %d/%d", attempt+1, config.Config.Retries))
+		}
+		time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
 	}
+}
 
-	var req *http.Request
-	req, err = http.NewRequest("POST", URL.String(), pipeReader)
-	if err != nil {
-		return
+// uploadName returns the name of the file in the upload. Files
+// of directories uploaded recursively have their path as name.
+func uploadName(fileToUpload *config.FileToUpload) string {
+	if fileToUpload.Name != "" {
+		return fileToUpload.Name
 	}
+	return fileToUpload.Base
+}
 
-	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
-	req.Header.Set("X-ClientApp", "cli_client")
-	req.Header.Set("X-UploadToken", uploadInfo.UploadToken)
+// uploadStream uploads data that can't be read twice so it is not retried
+func uploadStream(uploadInfo *common.Upload, name string, reader io.Reader) (file *common.File, err error) {
+	var progress func(n int)
+	if !config.Config.Quiet {
+		bar := newProgressBar(name, 0)
+		bar.Start()
+		defer bar.Finish()
+		progress = func(n int) { bar.Add(n) }
+	}
+	return upload(uploadInfo, name, reader, 0, progress)
+}
 
-	if uploadInfo.ProtectedByPassword {
-		req.Header.Set("Authorization", basicAuth)
+// newProgressBar returns a progress bar as wide as the terminal
+func newProgressBar(name string, size int64) (bar *pb.ProgressBar) {
+	width := 100
+	if terminal, err := ts.GetSize(); err == nil && terminal.Col() > 0 {
+		width = terminal.Col()
 	}
 
-	var resp *http.Response
-	resp, err = client.Do(req)
-	if err != nil {
-		return
+	bar = pb.New64(size).SetUnits(pb.U_BYTES)
+	bar.Prefix(fmt.Sprintf("%-"+strconv.Itoa(config.GetLongestFilename())+"s : ", name))
+	bar.ShowSpeed = true
+	bar.ShowTimeLeft = true
+	bar.ShowFinalTime = false
+	bar.SetWidth(width)
+	bar.SetMaxWidth(width)
+	return
+}
+
+// permanentError is returned for uploads that would fail again
+type permanentError struct {
+	error
+}
+
+// upload encrypts the data and the name of the file if needed and sends it.
+// The size of the file is sent for the server to reject it before it is
+// sent if it is too big, 0 if it is unknown.
+func upload(uploadInfo *common.Upload, name string, reader io.Reader, size int64, progress func(n int)) (file *common.File, err error) {
+
+	// The server only gets encrypted names. The path of end-to-end
+	// encrypted files is encrypted with their name so the tree of
+	// the upload is only known by the recipients.
+	remoteName := name
+	if e2eBackend := config.GetE2EBackend(); e2eBackend != nil {
+		remoteName, err = e2eBackend.EncryptString(name)
+		if err != nil {
+			return
+		}
 	}
 
-	defer resp.Body.Close()
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
+	// The reader must not be used anymore when returning
+	// so a failed upload can be retried
+	if config.Config.Secure {
+		pipeReader, pipeWriter := io.Pipe()
+		done := make(chan struct{})
+		go func(reader io.Reader) {
+			defer close(done)
+			pipeWriter.CloseWithError(config.GetCryptoBackend().Encrypt(reader, pipeWriter))
+		}(reader)
+		defer func() {
+			pipeReader.Close()
+			<-done
+		}()
+		reader = pipeReader
+		size = 0
 	}
 
-	// Parse Json
-	file = new(common.File)
-	err = json.Unmarshal(responseBody, file)
+	file, err = client.AddFile(uploadInfo, remoteName, reader, &plik.FileParams{Size: size, Progress: progress})
 	if err != nil {
+		if e, ok := err.(*plik.Error); ok {
+			switch e.StatusCode {
+			case 401, 403, 404, 413:
+				err = permanentError{err}
+			}
+		}
 		return
 	}
 
@@ -340,6 +489,11 @@ func upload(uploadInfo *common.Upload, name string, size int64, reader io.Reader
 
 func getFileCommand(upload *common.Upload, file *common.File) (command string) {
 
+	// Only the plik client can decrypt end-to-end encrypted files
+	if config.GetE2EBackend() != nil {
+		return fmt.Sprintf("plik get '%s'", getFileURL(upload, file))
+	}
+
 	// Step one - Downloading file
 	switch config.Config.DownloadBinary {
 	case "wget":
@@ -350,7 +504,7 @@ func getFileCommand(upload *common.Upload, file *common.File) (command string) {
 		command += config.Config.DownloadBinary
 	}
 
-	command += fmt.Sprintf(" %s/file/%s/%s/%s", config.Config.URL, upload.ID, file.ID, file.Name)
+	command += " " + client.FileURL(upload, file)
 
 	// If Ssl
 	if config.Config.Secure {
@@ -371,11 +525,98 @@ func getFileCommand(upload *common.Upload, file *common.File) (command string) {
 	return
 }
 
+// getFileURL returns the url of the file. The key of end-to-end
+// encrypted uploads is in the fragment which is never sent to the server.
 func getFileURL(upload *common.Upload, file *common.File) (fileURL string) {
-	fileURL += fmt.Sprintf("%s/file/%s/%s/%s", config.Config.URL, upload.ID, file.ID, file.Name)
+	return client.FileURL(upload, file) + keyFragment()
+}
+
+func getUploadURL(upload *common.Upload) (uploadURL string) {
+	uploadURL = client.UploadURL(upload)
+	if e2eBackend := config.GetE2EBackend(); e2eBackend != nil {
+		uploadURL += "&key=" + e2eBackend.Config.Key
+	}
+	return
+}
+
+// uploadOutput is the document printed in JSON mode
+type uploadOutput struct {
+	ID          string        `json:"id"`
+	URL         string        `json:"url"`
+	UploadToken string        `json:"uploadToken"`
+	Size        int64         `json:"size"`
+	Files       []*fileOutput `json:"files"`
+}
+
+type fileOutput struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Size    int64  `json:"size"`
+	Md5     string `json:"md5"`
+	Sha256  string `json:"sha256,omitempty"`
+	URL     string `json:"url"`
+	Command string `json:"command"`
+}
+
+func printJSON(upload *common.Upload) (err error) {
+	output, err := newUploadOutput(upload)
+	if err != nil {
+		return
+	}
+	return printDocument(output)
+}
+
+// newUploadOutput returns the links and the commands to get the files
+// of the upload. Names of end-to-end encrypted files are decrypted.
+func newUploadOutput(upload *common.Upload) (output *uploadOutput, err error) {
+	output = new(uploadOutput)
+	output.ID = upload.ID
+	output.URL = getUploadURL(upload)
+	output.UploadToken = upload.UploadToken
+	output.Files = make([]*fileOutput, 0, len(upload.Files))
+
+	for _, file := range upload.Files {
+		name, filePath := file.Name, file.Path
+		if e2eBackend := config.GetE2EBackend(); e2eBackend != nil {
+			name, err = e2eBackend.DecryptString(file.Name)
+			if err != nil {
+				return nil, err
+			}
+			if strings.Contains(name, "/") {
+				name, filePath = path.Base(name), name
+			}
+		}
+
+		output.Size += file.CurrentSize
+		output.Files = append(output.Files, &fileOutput{
+			ID:      file.ID,
+			Name:    name,
+			Path:    filePath,
+			Size:    file.CurrentSize,
+			Md5:     file.Md5,
+			Sha256:  file.Sha256,
+			URL:     getFileURL(upload, file),
+			Command: getFileCommand(upload, file),
+		})
+	}
+	sort.Sort(filesOutputByName(output.Files))
 	return
 }
 
+type filesOutputByName []*fileOutput
+
+func (files filesOutputByName) Len() int           { return len(files) }
+func (files filesOutputByName) Swap(i, j int)      { files[i], files[j] = files[j], files[i] }
+func (files filesOutputByName) Less(i, j int) bool { return files[i].fullPath() < files[j].fullPath() }
+
+func (file *fileOutput) fullPath() string {
+	if file.Path != "" {
+		return file.Path
+	}
+	return file.Name
+}
+
 func printf(format string, args ...interface{}) {
 	if !config.Config.Quiet {
 		fmt.Printf(format, args...)
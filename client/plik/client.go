@@ -0,0 +1,222 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+// Package plik is a client of the plik server API. The uploads and the
+// files are the ones of the server ( common.Upload and common.File ).
+//
+//	client := plik.NewClient("https://plik.example.com")
+//	upload, err := client.CreateUpload(&common.Upload{TTL: 86400})
+//	file, err := client.AddFile(upload, "report.pdf", reader, nil)
+//	fmt.Println(client.FileURL(upload, file))
+package plik
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/root-gg/plik/server/common"
+)
+
+// Client sends the requests to a plik server
+type Client struct {
+	// URL of the server ( http://127.0.0.1:8080 )
+	URL string
+
+	// HTTPClient sends the requests, http.DefaultClient by default
+	HTTPClient *http.Client
+
+	// Token is sent in the X-PlikToken header if set
+	Token string
+
+	// ClientApp is sent in the X-ClientApp header
+	ClientApp string
+}
+
+// NewClient returns a client of the server at this URL
+func NewClient(URL string) (client *Client) {
+	client = new(Client)
+	client.URL = strings.TrimSuffix(URL, "/")
+	client.HTTPClient = http.DefaultClient
+	client.ClientApp = "plik_client"
+	return
+}
+
+// Error is returned when the server answers with an error status.
+// Code is one of the stable error codes of the server ( common.ErrUploadNotFound, ... ).
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsNotFound tells if the error is a 404 Not Found response
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized tells if the error is a 401 Unauthorized response
+// ( missing or invalid credentials of a password protected upload )
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// GetConfig returns the limits and the features of the server.
+// Servers older than this endpoint answer with a not found error.
+func (client *Client) GetConfig() (config *common.PublicConfiguration, err error) {
+	req, err := client.newRequest("GET", "/api/v1/config", nil, nil)
+	if err != nil {
+		return
+	}
+
+	config = new(common.PublicConfiguration)
+	err = client.doJSON(req, config)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetVersion returns the version of the server
+func (client *Client) GetVersion() (version string, err error) {
+	req, err := client.newRequest("GET", "/api/v1/version", nil, nil)
+	if err != nil {
+		return
+	}
+
+	result := new(common.VersionResult)
+	err = client.doJSON(req, result)
+	if err != nil {
+		return
+	}
+	return result.Version, nil
+}
+
+// newRequest returns a request to the server with the headers of the
+// client and the credentials of the upload if it is password protected
+func (client *Client) newRequest(method string, path string, upload *common.Upload, body io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequest(method, client.URL+path, body)
+	if err != nil {
+		return
+	}
+
+	if client.ClientApp != "" {
+		req.Header.Set("X-ClientApp", client.ClientApp)
+	}
+	if client.Token != "" {
+		req.Header.Set("X-PlikToken", client.Token)
+	}
+	if upload != nil {
+		if upload.UploadToken != "" {
+			req.Header.Set("X-UploadToken", upload.UploadToken)
+		}
+		if upload.Password != "" {
+			login := upload.Login
+			if login == "" {
+				login = "plik"
+			}
+			req.SetBasicAuth(login, upload.Password)
+		}
+	}
+	return
+}
+
+// do sends the request and returns an *Error if the server answers
+// with an unexpected status. The caller must close the response body.
+func (client *Client) do(req *http.Request, statuses ...int) (resp *http.Response, err error) {
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		return
+	}
+
+	if len(statuses) == 0 {
+		statuses = []int{http.StatusOK}
+	}
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return
+		}
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return nil, newError(resp, body)
+}
+
+// doJSON sends the request and decodes the response in value
+func (client *Client) doJSON(req *http.Request, value interface{}) (err error) {
+	resp, err := client.do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	if value == nil {
+		return
+	}
+	err = json.Unmarshal(body, value)
+	if err != nil {
+		return fmt.Errorf("Unable to decode response : %s", err)
+	}
+	return
+}
+
+// newError returns the error of a server response
+func newError(resp *http.Response, body []byte) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	result := new(common.Result)
+	if json.Unmarshal(body, result) == nil && result.Message != "" {
+		e.Message = result.Message
+		e.Code = result.Code
+	} else if message := strings.TrimSpace(string(body)); message != "" {
+		e.Message = message
+	} else {
+		e.Message = fmt.Sprintf("Unexpected HTTP status %s", resp.Status)
+	}
+	return e
+}
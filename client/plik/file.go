@@ -0,0 +1,212 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package plik
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/digest"
+)
+
+// FileParams are the optional parameters of AddFile
+type FileParams struct {
+	// Size of the data of the reader if it is known. The server
+	// rejects files too big before they are sent.
+	Size int64
+
+	// Progress is called with the number of bytes sent
+	// each time a part of the file has been sent
+	Progress func(n int)
+}
+
+// AddFile uploads the data of the reader as a file of the upload. Names
+// with a / are the path of the file in the tree of the upload ( dir/file.txt ).
+// The reader is not used anymore once AddFile returns so it can be
+// rewound to retry a failed upload.
+func (client *Client) AddFile(upload *common.Upload, name string, reader io.Reader, params *FileParams) (file *common.File, err error) {
+	if params == nil {
+		params = new(FileParams)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+
+	// The digest of the uploaded data is sent in a request trailer
+	// for the server to reject the file if it got corrupted
+	sha256Hash := sha256.New()
+	trailer := http.Header{"Repr-Digest": nil}
+
+	fileName, filePath := name, ""
+	if strings.Contains(name, "/") {
+		fileName, filePath = path.Base(name), name
+	}
+
+	// Errors are returned by the request reading the pipe
+	done := make(chan struct{})
+	defer func() {
+		pipeReader.Close()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		if filePath != "" {
+			if err := multipartWriter.WriteField("path", filePath); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		if params.Size > 0 {
+			if err := multipartWriter.WriteField("size", strconv.FormatInt(params.Size, 10)); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		part, err := multipartWriter.CreateFormFile("file", fileName)
+		if err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+
+		var writer io.Writer = io.MultiWriter(part, sha256Hash)
+		if params.Progress != nil {
+			writer = &progressWriter{writer, params.Progress}
+		}
+		_, err = io.Copy(writer, reader)
+		if err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+
+		err = multipartWriter.Close()
+		trailer.Set("Repr-Digest", digest.SHA256+"=:"+base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil))+":")
+		pipeWriter.CloseWithError(err)
+	}()
+
+	req, err := client.newRequest("POST", "/api/v1/uploads/"+upload.ID+"/files", upload, pipeReader)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	req.Trailer = trailer
+
+	var files []*common.File
+	err = client.doJSON(req, &files)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("Got %d files adding %s. Expected 1", len(files), name)
+	}
+	file = files[0]
+
+	// Servers not checking digests still return the sha256 of the file
+	if file.Sha256 != "" && file.Sha256 != hex.EncodeToString(sha256Hash.Sum(nil)) {
+		return nil, fmt.Errorf("Digest mismatch for %s : the file got corrupted during the upload", name)
+	}
+	return
+}
+
+// DownloadParams are the optional parameters of Download
+type DownloadParams struct {
+	// Offset of the first byte to download to resume a download.
+	// The response status is 206 Partial Content if the server sent
+	// the remaining bytes, 200 OK if it sent the whole file and 416
+	// Requested Range Not Satisfiable if there is nothing left to send.
+	Offset int64
+
+	// Yubikey OTP of uploads protected by a Yubikey
+	Yubikey string
+}
+
+// Download returns the response of the server with the data of the file
+// in its body. The caller must close the body. The sha256 of the file is
+// in file.Sha256 or in the Repr-Digest header of the response.
+func (client *Client) Download(upload *common.Upload, file *common.File, params *DownloadParams) (resp *http.Response, err error) {
+	if params == nil {
+		params = new(DownloadParams)
+	}
+
+	urlPath := fmt.Sprintf("/api/v1/uploads/%s/files/%s/%s", upload.ID, file.ID, url.PathEscape(file.Name))
+	req, err := client.newRequest("GET", urlPath, upload, nil)
+	if err != nil {
+		return
+	}
+	if params.Yubikey != "" {
+		req.Header.Set("X-Yubikey", params.Yubikey)
+	}
+
+	statuses := []int{http.StatusOK}
+	if params.Offset > 0 {
+		req.Header.Set("Accept-Encoding", "identity")
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(params.Offset, 10)+"-")
+		statuses = append(statuses, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	return client.do(req, statuses...)
+}
+
+// RemoveFile removes a file of a removable upload
+func (client *Client) RemoveFile(upload *common.Upload, file *common.File) (err error) {
+	req, err := client.newRequest("DELETE", "/api/v1/uploads/"+upload.ID+"/files/"+file.ID, upload, nil)
+	if err != nil {
+		return
+	}
+	return client.doJSON(req, nil)
+}
+
+// FileURL returns the url to download the file
+func (client *Client) FileURL(upload *common.Upload, file *common.File) string {
+	return fmt.Sprintf("%s/file/%s/%s/%s", client.URL, upload.ID, file.ID, url.PathEscape(file.Name))
+}
+
+// progressWriter reports the bytes written to the progress callback
+type progressWriter struct {
+	writer   io.Writer
+	progress func(n int)
+}
+
+func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	n, err = pw.writer.Write(p)
+	if n > 0 {
+		pw.progress(n)
+	}
+	return
+}
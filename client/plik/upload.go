@@ -0,0 +1,137 @@
+/**
+
+    Plik upload client
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package plik
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/root-gg/plik/server/common"
+)
+
+// CreateUpload creates an upload with the options of params ( OneShot,
+// Removable, TTL, Login, Password, Comments, ... ). The returned upload
+// has the upload token and the credentials needed to add files.
+func (client *Client) CreateUpload(params *common.Upload) (upload *common.Upload, err error) {
+	if params == nil {
+		params = new(common.Upload)
+	}
+
+	j, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+
+	req, err := client.newRequest("POST", "/api/v1/uploads", nil, bytes.NewBuffer(j))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The server needs the referer to shorten the upload url
+	req.Header.Set("Referer", client.URL)
+
+	upload = new(common.Upload)
+	err = client.doJSON(req, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	keepCredentials(params, upload)
+	return
+}
+
+// GetUpload returns the metadata of the upload and its files. The
+// upload must have its ID and the credentials if it is password protected.
+func (client *Client) GetUpload(upload *common.Upload) (uploadInfo *common.Upload, err error) {
+	req, err := client.newRequest("GET", "/api/v1/uploads/"+upload.ID, upload, nil)
+	if err != nil {
+		return
+	}
+
+	uploadInfo = new(common.Upload)
+	err = client.doJSON(req, uploadInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	keepCredentials(upload, uploadInfo)
+	return
+}
+
+// RemoveUpload removes the upload and all its files.
+// The upload token is required.
+func (client *Client) RemoveUpload(upload *common.Upload) (err error) {
+	req, err := client.newRequest("DELETE", "/api/v1/uploads/"+upload.ID, upload, nil)
+	if err != nil {
+		return
+	}
+	return client.doJSON(req, nil)
+}
+
+// UpdateTTL changes the expiration of the upload to ttl seconds from
+// now ( 0 for the default ttl of the server, -1 for no expiration ).
+// The upload token is required.
+func (client *Client) UpdateTTL(upload *common.Upload, ttl int) (uploadInfo *common.Upload, err error) {
+	req, err := client.newRequest("PATCH", "/api/v1/uploads/"+upload.ID, upload, bytes.NewBufferString(fmt.Sprintf(`{"ttl":%d}`, ttl)))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	uploadInfo = new(common.Upload)
+	err = client.doJSON(req, uploadInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	keepCredentials(upload, uploadInfo)
+	return
+}
+
+// UploadURL returns the url of the upload in the web interface
+func (client *Client) UploadURL(upload *common.Upload) string {
+	return fmt.Sprintf("%s/#/?id=%s", client.URL, upload.ID)
+}
+
+// keepCredentials copies the upload token and the credentials the
+// server never sends back so the upload can be used in next requests
+func keepCredentials(from *common.Upload, to *common.Upload) {
+	if to.UploadToken == "" {
+		to.UploadToken = from.UploadToken
+	}
+	if from.Password != "" {
+		if from.Login != "" {
+			to.Login = from.Login
+		}
+		to.Password = from.Password
+	}
+}
@@ -0,0 +1,319 @@
+/* The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/openapi"
+)
+
+// apiRoute is an operation of the /api/v1 surface. The router and the
+// OpenAPI document served at /api/v1/openapi.json are both built from
+// apiRoutes so the documentation can't get out of sync with the routes.
+type apiRoute struct {
+	id        string
+	methods   []string
+	path      string
+	handler   http.HandlerFunc
+	summary   string
+	headers   []string
+	query     []string
+	request   interface{} // JSON request body
+	multipart bool        // multipart/form-data request body with files
+	raw       bool        // file data request body and text/plain response
+	options   bool        // upload options as query parameters or X-Plik-* headers
+	response  interface{} // JSON response body
+	download  bool        // file data response body
+}
+
+// ttlParams is the body of the requests changing the ttl of an upload
+type ttlParams struct {
+	TTL      int        `json:"ttl" openapi:"integer|string"`
+	ExpireAt *time.Time `json:"expireAt,omitempty"`
+}
+
+// UnmarshalJSON accepts a ttl in seconds or as a duration string ( "90m" )
+func (params *ttlParams) UnmarshalJSON(data []byte) (err error) {
+	type ttlParamsJSON ttlParams
+	value := struct {
+		*ttlParamsJSON
+		TTL json.RawMessage `json:"ttl"`
+	}{ttlParamsJSON: (*ttlParamsJSON)(params)}
+
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return
+	}
+	if value.TTL != nil {
+		params.TTL, err = common.UnmarshalTTL(value.TTL)
+	}
+	return
+}
+
+var apiRoutes = []*apiRoute{
+	{
+		id: "createUpload", methods: []string{"POST"}, path: "/uploads", handler: createUploadHandler,
+		summary: "Create an upload. The response has the upload token required to add files. Files can be added at once in a multipart request.",
+		request: common.Upload{}, multipart: true, options: true, response: common.Upload{},
+	},
+	{
+		id: "getUpload", methods: []string{"GET"}, path: "/uploads/{uploadID}", handler: getUploadHandler,
+		summary:  "Get the metadata of an upload and its files",
+		response: common.Upload{},
+	},
+	{
+		id: "updateUpload", methods: []string{"PATCH"}, path: "/uploads/{uploadID}", handler: updateTTLHandler,
+		summary: "Change the ttl of an upload in seconds from now ( 0 for the default ttl, -1 for no expiration ) or its expiration date",
+		headers: []string{"X-UploadToken"}, request: ttlParams{}, response: common.Upload{},
+	},
+	{
+		id: "removeUpload", methods: []string{"DELETE"}, path: "/uploads/{uploadID}", handler: removeUploadHandler,
+		summary: "Remove an upload and all its files",
+		headers: []string{"X-UploadToken"}, response: common.Result{},
+	},
+	{
+		id: "addFile", methods: []string{"POST"}, path: "/uploads/{uploadID}/files", handler: addFilesHandler,
+		summary: "Add files to an upload. Files are added only if all of them are saved.",
+		headers: []string{"X-UploadToken", "Repr-Digest"}, multipart: true, response: []*common.File{},
+	},
+	{
+		id: "putFile", methods: []string{"PUT"}, path: "/put/{filename}", handler: putFileHandler,
+		summary: "Create an upload with the request body as its only file. The response is the download url unless JSON is preferred.",
+		headers: []string{"Repr-Digest"}, raw: true, options: true, response: common.UploadResult{},
+	},
+	{
+		id: "getFile", methods: []string{"GET", "HEAD"}, path: "/uploads/{uploadID}/files/{fileID}/{filename}", handler: getFileHandler,
+		summary: "Download a file",
+		headers: []string{"X-Yubikey", "Range", "Accept-Encoding"}, query: []string{"dl"}, download: true,
+	},
+	{
+		id: "removeFile", methods: []string{"DELETE"}, path: "/uploads/{uploadID}/files/{fileID}", handler: removeFileHandler,
+		summary:  "Remove a file of a removable upload",
+		response: common.Result{},
+	},
+	{
+		id: "getPreview", methods: []string{"GET", "HEAD"}, path: "/uploads/{uploadID}/previews/{fileID}", handler: getPreviewHandler,
+		summary: "Download the preview of a file",
+		headers: []string{"X-Yubikey"}, download: true,
+	},
+	{
+		id: "getTree", methods: []string{"GET", "HEAD"}, path: "/uploads/{uploadID}/tree/{path:.*}", handler: getTreeHandler,
+		summary: "Download the file at this path or list the files of this directory ( empty for the root )",
+		headers: []string{"X-Yubikey"}, response: []*common.File{}, download: true,
+	},
+	{
+		id: "getArchive", methods: []string{"GET", "HEAD"}, path: "/uploads/{uploadID}/archive/{filename}", handler: getArchiveHandler,
+		summary: "Download the files of an upload in a .zip or .tar.gz archive",
+		headers: []string{"X-Yubikey"}, download: true,
+	},
+	{
+		id: "getConfig", methods: []string{"GET"}, path: "/config", handler: getConfigHandler,
+		summary:  "Get the limits and the features of the server",
+		response: common.PublicConfiguration{},
+	},
+	{
+		id: "getVersion", methods: []string{"GET"}, path: "/version", handler: getVersionHandler,
+		summary:  "Get the version of the server",
+		response: common.VersionResult{},
+	},
+	{
+		id: "getOpenAPI", methods: []string{"GET"}, path: "/openapi.json", handler: getOpenAPIHandler,
+		summary:  "Get this OpenAPI document",
+		response: map[string]interface{}{},
+	},
+}
+
+var apiParameters = map[string]string{
+	"X-UploadToken":   "Upload token returned at the creation of the upload",
+	"X-Yubikey":       "Yubikey OTP of uploads protected by a Yubikey",
+	"Repr-Digest":     "Expected digest of the file of requests with a single file ( sha-256=:base64: ), also accepted as a trailer",
+	"Range":           "Single byte range to resume a download",
+	"Accept-Encoding": "Compressible files are sent compressed with zstd, br or gzip",
+	"dl":              "Set to 1 to force the download in a browser",
+}
+
+var pathParameter = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// apiDocument is the OpenAPI document of the /api/v1 routes
+var apiDocument *openapi.Document
+
+func newAPIDocument() (doc *openapi.Document) {
+	doc = openapi.NewDocument("Plik", "1")
+	doc.Info.Description = "Plik file upload server API. Errors have a stable code ( see the Result schema )."
+	doc.Servers = []*openapi.Server{{URL: "/api/v1"}}
+
+	for _, route := range apiRoutes {
+		operation := &openapi.Operation{
+			OperationID: route.id,
+			Summary:     route.summary,
+			Responses:   make(map[string]*openapi.Response),
+		}
+
+		for _, match := range pathParameter.FindAllStringSubmatch(route.path, -1) {
+			operation.Parameters = append(operation.Parameters, &openapi.Parameter{Name: match[1], In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}})
+		}
+		for _, name := range route.headers {
+			operation.Parameters = append(operation.Parameters, &openapi.Parameter{Name: name, In: "header", Description: apiParameters[name], Schema: &openapi.Schema{Type: "string"}})
+		}
+		for _, name := range route.query {
+			operation.Parameters = append(operation.Parameters, &openapi.Parameter{Name: name, In: "query", Description: apiParameters[name], Schema: &openapi.Schema{Type: "string"}})
+		}
+		if route.options {
+			for _, option := range uploadOptions {
+				operation.Parameters = append(operation.Parameters, &openapi.Parameter{Name: option.name, In: "query", Description: option.description, Schema: &openapi.Schema{Type: "string"}})
+				operation.Parameters = append(operation.Parameters, &openapi.Parameter{Name: option.header, In: "header", Description: option.description, Schema: &openapi.Schema{Type: "string"}})
+			}
+		}
+
+		if route.raw {
+			operation.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]*openapi.MediaType{"application/octet-stream": {Schema: &openapi.Schema{Type: "string", Format: "binary"}}},
+			}
+		}
+		if route.request != nil || route.multipart {
+			operation.RequestBody = &openapi.RequestBody{Content: make(map[string]*openapi.MediaType)}
+		}
+		if route.request != nil {
+			operation.RequestBody.Content["application/json"] = &openapi.MediaType{Schema: doc.SchemaOf(route.request)}
+		}
+		if route.multipart {
+			form := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+				"path":   {Type: "string", Description: "Path of the next file in the tree of the upload"},
+				"size":   {Type: "integer", Description: "Size of the next file to reject it before it is sent if it is too big"},
+				"digest": {Type: "string", Description: "Expected digest of the next file ( sha-256=:base64: )"},
+				"file":   {Type: "string", Format: "binary", Description: "Data of a file, repeated for each file"},
+			}}
+
+			// Files are optional when they are sent with the JSON body
+			// of the request in a first part of the form
+			if route.request != nil {
+				form.Properties["upload"] = &openapi.Schema{Type: "string", Description: "JSON request body, it must be the first part"}
+			} else {
+				form.Required = []string{"file"}
+				operation.RequestBody.Required = true
+			}
+			operation.RequestBody.Content["multipart/form-data"] = &openapi.MediaType{Schema: form}
+		}
+
+		success := &openapi.Response{Description: "OK", Content: make(map[string]*openapi.MediaType)}
+		if route.response != nil {
+			success.Content["application/json"] = &openapi.MediaType{Schema: doc.SchemaOf(route.response)}
+		}
+		if route.raw {
+			success.Content["text/plain"] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string"}}
+		}
+		if route.download {
+			success.Content["application/octet-stream"] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string", Format: "binary"}}
+		}
+		operation.Responses["200"] = success
+		operation.Responses["default"] = &openapi.Response{
+			Description: "Error",
+			Content:     map[string]*openapi.MediaType{"application/json": {Schema: doc.SchemaOf(common.Result{})}},
+		}
+
+		// HEAD requests are documented by their GET operation
+		doc.AddOperation(pathParameter.ReplaceAllString(route.path, "{$1}"), route.methods[0], operation)
+	}
+	return
+}
+
+// registerAPI adds the /api/v1 routes to the router
+func registerAPI(r *mux.Router) {
+	apiDocument = newAPIDocument()
+	api := r.PathPrefix("/api/v1").Subrouter()
+	for _, route := range apiRoutes {
+		handler := route.handler
+		if route.request != nil {
+			handler = validateRequest(apiDocument.SchemaOf(route.request), handler)
+		}
+		api.HandleFunc(route.path, handler).Methods(route.methods...)
+	}
+}
+
+// validateRequest rejects the requests with a JSON body not matching
+// the schema. Handlers decide if the body is required.
+func validateRequest(schema *openapi.Schema, handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		// Multipart requests with files are checked by the handler
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+			handler(resp, req)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, 1048576))
+		if err != nil {
+			writeError(resp, common.ErrInvalidRequest, "Unable to read request body", 400)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(bytes.TrimSpace(body)) == 0 {
+			handler(resp, req)
+			return
+		}
+
+		var value interface{}
+		err = json.Unmarshal(body, &value)
+		if err != nil {
+			writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Invalid json request body : %s", err), 400)
+			return
+		}
+		err = apiDocument.Validate(schema, value)
+		if err != nil {
+			writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Invalid request body : %s", err), 400)
+			return
+		}
+
+		handler(resp, req)
+	}
+}
+
+// deprecated marks the responses of the routes replaced by the /api/v1 routes
+func deprecated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Deprecation", "true")
+		resp.Header().Set("Link", "</api/v1/openapi.json>; rel=\"deprecation\"")
+		handler(resp, req)
+	}
+}
+
+func getOpenAPIHandler(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(resp)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(apiDocument); err != nil {
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+	}
+}
@@ -0,0 +1,137 @@
+/* The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE. */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"time"
+
+	"github.com/root-gg/plik/server/common"
+)
+
+// archiveWriter streams upload files into an archive. Files are
+// written one after the other so memory usage does not depend
+// on the number or the size of the files.
+type archiveWriter interface {
+	AddFile(file *common.File, reader io.Reader) (err error)
+	Close() (err error)
+}
+
+// archiveEntryNames keeps track of the names already used in an archive.
+// Files are stored with their path in the tree of the upload. Several files
+// of an upload may have the same path, the file id is then used as a
+// directory to avoid overwriting files when extracting.
+type archiveEntryNames map[string]bool
+
+func (names archiveEntryNames) get(file *common.File) (name string) {
+	name = file.FullPath()
+	if names[name] {
+		name = file.ID + "/" + file.FullPath()
+	}
+	names[name] = true
+	return
+}
+
+type zipArchiveWriter struct {
+	writer *zip.Writer
+	names  archiveEntryNames
+}
+
+func newZipArchiveWriter(writer io.Writer) *zipArchiveWriter {
+	return &zipArchiveWriter{writer: zip.NewWriter(writer), names: make(archiveEntryNames)}
+}
+
+// AddFile implementation for zip archives
+func (zw *zipArchiveWriter) AddFile(file *common.File, reader io.Reader) (err error) {
+	header := &zip.FileHeader{
+		Name:     zw.names.get(file),
+		Method:   zip.Deflate,
+		Modified: time.Unix(file.UploadDate, 0),
+	}
+	w, err := zw.writer.CreateHeader(header)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, reader)
+	return
+}
+
+// Close implementation for zip archives
+func (zw *zipArchiveWriter) Close() (err error) {
+	return zw.writer.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gzipWriter *gzip.Writer
+	writer     *tar.Writer
+	names      archiveEntryNames
+}
+
+func newTarGzArchiveWriter(writer io.Writer) *tarGzArchiveWriter {
+	gzipWriter := gzip.NewWriter(writer)
+	return &tarGzArchiveWriter{gzipWriter: gzipWriter, writer: tar.NewWriter(gzipWriter), names: make(archiveEntryNames)}
+}
+
+// AddFile implementation for tar.gz archives
+func (tw *tarGzArchiveWriter) AddFile(file *common.File, reader io.Reader) (err error) {
+	header := &tar.Header{
+		Name:     tw.names.get(file),
+		Mode:     0644,
+		Size:     file.CurrentSize,
+		ModTime:  time.Unix(file.UploadDate, 0),
+		Typeflag: tar.TypeReg,
+	}
+	err = tw.writer.WriteHeader(header)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(tw.writer, reader)
+	return
+}
+
+// Close implementation for tar.gz archives
+func (tw *tarGzArchiveWriter) Close() (err error) {
+	err = tw.writer.Close()
+	if err != nil {
+		return
+	}
+	return tw.gzipWriter.Close()
+}
+
+// filesByName sorts files by path then by id so
+// archives content is always in the same order
+type filesByName []*common.File
+
+func (files filesByName) Len() int      { return len(files) }
+func (files filesByName) Swap(i, j int) { files[i], files[j] = files[j], files[i] }
+func (files filesByName) Less(i, j int) bool {
+	if files[i].FullPath() == files[j].FullPath() {
+		return files[i].ID < files[j].ID
+	}
+	return files[i].FullPath() < files[j].FullPath()
+}
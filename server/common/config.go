@@ -30,9 +30,15 @@ THE SOFTWARE.
 package common
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/url"
+
 	"github.com/BurntSushi/toml"
 	"github.com/GeertJohan/yubigo"
 	"github.com/root-gg/logger"
+	"github.com/root-gg/plik/server/compression"
+	"github.com/root-gg/plik/server/digest"
 )
 
 var (
@@ -49,15 +55,44 @@ type Configuration struct {
 	DefaultTTL int
 	MaxTTL     int
 
+	PublicURL         string
+	ExpirationWarning int
+	ExpirationWebhook string
+	ExtendSecret      string
+	TombstoneTTL      int
+
+	SmtpServer   string
+	SmtpFrom     string
+	SmtpUsername string
+	SmtpPassword string
+
 	SslEnabled bool
 	SslCert    string
 	SslKey     string
 
+	InlineContentTypes []string
+	DownloadDomain     string
+	DownloadDomainURL  *url.URL
+
+	CompressionEnabled       bool
+	CompressionEncodings     []string
+	CompressibleContentTypes []string
+	CompressionAtRest        string
+
+	DedupEnabled bool
+
+	ExtraDigests []string
+
 	YubikeyEnabled   bool
 	YubikeyAPIKey    string
 	YubikeyAPISecret string
 	YubiAuth         *yubigo.YubiAuth
 
+	PreviewEnabled       bool
+	PreviewMaxFileSize   int
+	PreviewThumbnailSize int
+	PreviewTextSize      int
+
 	MetadataBackend       string
 	MetadataBackendConfig map[string]interface{}
 
@@ -71,6 +106,26 @@ type Configuration struct {
 // Global var to store conf
 var Config *Configuration
 
+// PublicConfiguration holds the limits and the features of the
+// server that clients need to know. No secret must be added here.
+type PublicConfiguration struct {
+	Version        string   `json:"version"`
+	MaxFileSize    int      `json:"maxFileSize"`
+	DefaultTTL     int      `json:"defaultTTL"`
+	MaxTTL         int      `json:"maxTTL"`
+	YubikeyEnabled bool     `json:"yubikeyEnabled"`
+	ShortenBackend string   `json:"shortenBackend"`
+	PreviewEnabled bool     `json:"previewEnabled"`
+	Compression    []string `json:"compression"`
+	Digests        []string `json:"digests"`
+	DownloadDomain string   `json:"downloadDomain,omitempty"`
+}
+
+// VersionResult is the response of the version endpoint
+type VersionResult struct {
+	Version string `json:"version"`
+}
+
 // NewConfiguration creates a new configuration
 // object with default values
 func NewConfiguration() (this *Configuration) {
@@ -82,9 +137,24 @@ func NewConfiguration() (this *Configuration) {
 	this.MaxFileSize = 1048576 // 1MB
 	this.DefaultTTL = 2592000  // 30 days
 	this.MaxTTL = 0
+	this.PublicURL = ""
+	this.ExpirationWarning = 24 // hours
+	this.TombstoneTTL = 2592000 // 30 days
 	this.SslEnabled = false
 	this.SslCert = ""
 	this.SslKey = ""
+	this.InlineContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/bmp", "image/webp", "text/plain", "application/pdf", "audio/*", "video/*"}
+	this.DownloadDomain = ""
+	this.CompressionEnabled = false
+	this.CompressionEncodings = []string{compression.Zstd, compression.Brotli, compression.Gzip}
+	this.CompressibleContentTypes = []string{"text/*", "application/json", "application/xml", "application/javascript", "image/svg+xml"}
+	this.CompressionAtRest = ""
+	this.DedupEnabled = false
+	this.ExtraDigests = []string{}
+	this.PreviewEnabled = false
+	this.PreviewMaxFileSize = 10485760 // 10MB
+	this.PreviewThumbnailSize = 256
+	this.PreviewTextSize = 4096
 	return
 }
 
@@ -105,6 +175,46 @@ func LoadConfiguration(file string) {
 		Log().SetFlags(logger.Fdate | logger.Flevel | logger.FfixedSizeLevel)
 	}
 
+	// Files are served only from the download domain if any
+	if Config.DownloadDomain != "" {
+		u, err := url.Parse(Config.DownloadDomain)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			Log().Fatalf("Invalid download domain %s, it must look like https://dl.example.com", Config.DownloadDomain)
+		}
+		Config.DownloadDomainURL = u
+	}
+
+	// Check compression encodings
+	for _, encoding := range Config.CompressionEncodings {
+		if !compression.IsSupported(encoding) {
+			Log().Fatalf("Invalid compression encoding %s (available : gzip, br, zstd)", encoding)
+		}
+	}
+	if Config.CompressionAtRest != "" && !compression.IsSupported(Config.CompressionAtRest) {
+		Log().Fatalf("Invalid compression at rest encoding %s (available : gzip, br, zstd)", Config.CompressionAtRest)
+	}
+
+	// Check digest algorithms
+	for _, algorithm := range Config.ExtraDigests {
+		if !digest.IsSupported(algorithm) {
+			Log().Fatalf("Invalid digest algorithm %s (available : blake2b-512, xxh64)", algorithm)
+		}
+	}
+
+	// Extend links are signed with a random secret if none is
+	// configured, they are valid until the server restarts
+	if Config.ExtendSecret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		Config.ExtendSecret = base64.StdEncoding.EncodeToString(secret)
+		if Config.ExpirationWarning > 0 && (Config.ExpirationWebhook != "" || Config.SmtpServer != "") {
+			Log().Warningf("No ExtendSecret configured, extend links of expiration warnings will be invalid after a restart")
+		}
+	}
+	if Config.PublicURL == "" && Config.ExpirationWarning > 0 && (Config.ExpirationWebhook != "" || Config.SmtpServer != "") {
+		Log().Warningf("No PublicURL configured, links of expiration warnings will be relative")
+	}
+
 	// Do user specified a ApiKey and ApiSecret for Yubikey
 	if Config.YubikeyEnabled {
 		yubiAuth, err := yubigo.NewYubiAuth(Config.YubikeyAPIKey, Config.YubikeyAPISecret)
@@ -117,6 +227,25 @@ func LoadConfiguration(file string) {
 	}
 }
 
+// Public returns the part of the configuration exposed to clients
+func (config *Configuration) Public() (public *PublicConfiguration) {
+	public = new(PublicConfiguration)
+	public.Version = GetVersion()
+	public.MaxFileSize = config.MaxFileSize
+	public.DefaultTTL = config.DefaultTTL
+	public.MaxTTL = config.MaxTTL
+	public.YubikeyEnabled = config.YubikeyEnabled
+	public.ShortenBackend = config.ShortenBackend
+	public.PreviewEnabled = config.PreviewEnabled
+	public.Compression = []string{}
+	if config.CompressionEnabled {
+		public.Compression = append(public.Compression, config.CompressionEncodings...)
+	}
+	public.Digests = append([]string{digest.SHA256}, config.ExtraDigests...)
+	public.DownloadDomain = config.DownloadDomain
+	return
+}
+
 // GetVersion return the hardcoded version
 // before compilation
 func GetVersion() string {
@@ -0,0 +1,57 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package common
+
+// Codes of the error responses. Messages may change but
+// these codes are stable so clients can tell errors apart.
+const (
+	ErrInvalidRequest     = "invalid_request"
+	ErrInternal           = "internal_error"
+	ErrUploadNotFound     = "upload_not_found"
+	ErrUploadExpired      = "upload_expired"
+	ErrFileNotFound       = "file_not_found"
+	ErrFileUnavailable    = "file_unavailable"
+	ErrFileExists         = "file_exists"
+	ErrFileTooBig         = "file_too_big"
+	ErrDigestMismatch     = "digest_mismatch"
+	ErrPathNotFound       = "path_not_found"
+	ErrPreviewNotFound    = "preview_not_found"
+	ErrInvalidTTL         = "invalid_ttl"
+	ErrTTLExceeded        = "ttl_exceeded"
+	ErrInvalidRange       = "invalid_range"
+	ErrInvalidArchiveName = "invalid_archive_name"
+	ErrNoFileAvailable    = "no_file_available"
+	ErrNotRemovable       = "not_removable"
+	ErrAuthRequired       = "auth_required"
+	ErrUploadTokenInvalid = "upload_token_invalid"
+	ErrInvalidSignature   = "invalid_signature"
+	ErrYubikeyInvalid     = "yubikey_invalid"
+	ErrYubikeyDisabled    = "yubikey_disabled"
+)
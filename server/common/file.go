@@ -29,16 +29,32 @@ THE SOFTWARE.
 
 package common
 
+import (
+	"errors"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/root-gg/plik/server/digest"
+)
+
 // File object
 type File struct {
 	ID             string                 `json:"id" bson:"fileId"`
 	Name           string                 `json:"fileName" bson:"fileName"`
+	Path           string                 `json:"filePath,omitempty" bson:"filePath,omitempty"`
 	Md5            string                 `json:"fileMd5" bson:"fileMd5"`
+	Sha256         string                 `json:"fileSha256" bson:"fileSha256"`
+	Blake2b        string                 `json:"fileBlake2b,omitempty" bson:"fileBlake2b,omitempty"`
+	Xxh64          string                 `json:"fileXxh64,omitempty" bson:"fileXxh64,omitempty"`
 	Status         string                 `json:"status" bson:"status"`
 	Type           string                 `json:"fileType" bson:"fileType"`
 	UploadDate     int64                  `json:"fileUploadDate" bson:"fileUploadDate"`
 	CurrentSize    int64                  `json:"fileSize" bson:"fileSize"`
+	Encoding       string                 `json:"encoding,omitempty" bson:"encoding"`
 	BackendDetails map[string]interface{} `json:"backendDetails,omitempty" bson:"backendDetails"`
+	BlobID         string                 `json:"blobId,omitempty" bson:"blobId,omitempty"`
+	Preview        *File                  `json:"preview,omitempty" bson:"preview,omitempty"`
 }
 
 // NewFile instantiate a new object
@@ -53,4 +69,82 @@ func NewFile() (file *File) {
 // object. Used to hide information in API.
 func (file *File) Sanitize() {
 	file.BackendDetails = nil
+	file.Encoding = ""
+	file.BlobID = ""
+	if file.Preview != nil {
+		file.Preview.Sanitize()
+	}
+}
+
+// FullPath returns the path of the file in the tree of the upload.
+// Files uploaded without a path are at the root of the tree.
+func (file *File) FullPath() string {
+	if file.Path != "" {
+		return file.Path
+	}
+	return file.Name
+}
+
+// CleanPath checks that a file path is relative and stays
+// inside the tree of the upload and returns its clean form
+func CleanPath(filePath string) (string, error) {
+	filePath = strings.Replace(filePath, "\\", "/", -1)
+	if strings.HasPrefix(filePath, "/") {
+		return "", errors.New("path must be relative")
+	}
+	for _, part := range strings.Split(filePath, "/") {
+		if part == ".." {
+			return "", errors.New("path must not contain ..")
+		}
+	}
+	filePath = path.Clean(filePath)
+	if filePath == "." {
+		return "", errors.New("empty path")
+	}
+	return filePath, nil
+}
+
+// Digests returns the hex encoded digests of the file content by algorithm
+func (file *File) Digests() (digests map[string]string) {
+	digests = make(map[string]string)
+	if file.Sha256 != "" {
+		digests[digest.SHA256] = file.Sha256
+	}
+	if file.Blake2b != "" {
+		digests[digest.BLAKE2b] = file.Blake2b
+	}
+	if file.Xxh64 != "" {
+		digests[digest.XXH64] = file.Xxh64
+	}
+	return
+}
+
+// SetDigest saves the hex encoded digest of the file content
+func (file *File) SetDigest(algorithm string, sum string) {
+	switch algorithm {
+	case digest.SHA256:
+		file.Sha256 = sum
+	case digest.BLAKE2b:
+		file.Blake2b = sum
+	case digest.XXH64:
+		file.Xxh64 = sum
+	}
+}
+
+// MatchContentType tells if the media type of contentType is in the list.
+// Patterns may be a full media type (text/plain) or a wildcard (video/*).
+func MatchContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
 }
@@ -35,12 +35,21 @@ import (
 	"github.com/root-gg/utils"
 )
 
-// Result object
+// Result object. Error results have a stable code
+// clients can rely on ( see error.go ).
 type Result struct {
 	Message string      `json:"message"`
+	Code    string      `json:"code,omitempty"`
 	Value   interface{} `json:"value"`
 }
 
+// UploadResult is the response of the requests creating
+// an upload and its file at once, with the url to share
+type UploadResult struct {
+	URL    string  `json:"url"`
+	Upload *Upload `json:"upload"`
+}
+
 // NewResult takes a message and a interface and
 // creates a new result object with them
 func NewResult(message string, value interface{}) (r *Result) {
@@ -50,6 +59,15 @@ func NewResult(message string, value interface{}) (r *Result) {
 	return
 }
 
+// NewErrorResult takes an error code and a message
+// and creates a new error result object with them
+func NewErrorResult(code string, message string) (r *Result) {
+	r = new(Result)
+	r.Message = message
+	r.Code = code
+	return
+}
+
 // ToJSON serialize result object to JSON
 func (result *Result) ToJSON() []byte {
 	j, err := utils.ToJson(result)
@@ -0,0 +1,48 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015> Copyright holders list can be found in AUTHORS file
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package common
+
+// Tombstone is what remains of an expired upload once it has been
+// removed. It is kept TombstoneTTL seconds so the API can tell that
+// a link has expired instead of answering it does not exist.
+type Tombstone struct {
+	ID         string `json:"id" bson:"id"`
+	Creation   int64  `json:"uploadDate" bson:"uploadDate"`
+	Expiration int64  `json:"expireDate" bson:"expireDate"`
+}
+
+// NewTombstone returns the tombstone of an expired upload
+func NewTombstone(upload *Upload) (tombstone *Tombstone) {
+	tombstone = new(Tombstone)
+	tombstone.ID = upload.ID
+	tombstone.Creation = upload.Creation
+	tombstone.Expiration = upload.Creation + int64(upload.TTL)
+	return
+}
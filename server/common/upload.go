@@ -30,7 +30,12 @@ THE SOFTWARE.
 package common
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -47,7 +52,13 @@ type Upload struct {
 	RemoteIP    string           `json:"uploadIp,omitempty" bson:"uploadIp"`
 	ShortURL    string           `json:"shortUrl" bson:"shortUrl"`
 	UploadToken string           `json:"uploadToken,omitempty" bson:"uploadToken"`
-	TTL         int              `json:"ttl" bson:"ttl"`
+	TTL         int              `json:"ttl" bson:"ttl" openapi:"integer|string"`
+	ExpireAt    *time.Time       `json:"expireAt" bson:"-"`
+
+	// Email of the owner to warn before the expiration and the
+	// expiration date the owner has already been warned of
+	Email            string `json:"email,omitempty" bson:"email"`
+	ExpirationWarned int64  `json:"expirationWarned,omitempty" bson:"expirationWarned"`
 
 	OneShot   bool `json:"oneShot" bson:"oneShot"`
 	Removable bool `json:"removable" bson:"removable"`
@@ -75,6 +86,45 @@ func (upload *Upload) Create() {
 	upload.Creation = time.Now().Unix()
 	upload.Files = make(map[string]*File)
 	upload.UploadToken = GenerateRandomID(32)
+	upload.ExpirationWarned = 0
+}
+
+// ExpirationDate returns the date the upload expires at from its
+// creation and its ttl, or nil if it never expires
+func (upload *Upload) ExpirationDate() *time.Time {
+	if upload.TTL <= 0 {
+		return nil
+	}
+	date := time.Unix(upload.Creation+int64(upload.TTL), 0).UTC()
+	return &date
+}
+
+// MarshalJSON sets the expireAt field of created uploads to the
+// expiration date computed from their ttl
+func (upload Upload) MarshalJSON() ([]byte, error) {
+	type uploadJSON Upload
+	if upload.Creation > 0 {
+		upload.ExpireAt = upload.ExpirationDate()
+	}
+	return json.Marshal(uploadJSON(upload))
+}
+
+// UnmarshalJSON accepts a ttl in seconds or as a duration string ( "90m" )
+func (upload *Upload) UnmarshalJSON(data []byte) (err error) {
+	type uploadJSON Upload
+	value := struct {
+		*uploadJSON
+		TTL json.RawMessage `json:"ttl"`
+	}{uploadJSON: (*uploadJSON)(upload)}
+
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return
+	}
+	if value.TTL != nil {
+		upload.TTL, err = UnmarshalTTL(value.TTL)
+	}
+	return
 }
 
 // Sanitize removes sensible information from
@@ -84,11 +134,95 @@ func (upload *Upload) Sanitize() {
 	upload.Password = ""
 	upload.Yubikey = ""
 	upload.UploadToken = ""
+	upload.Email = ""
+	upload.ExpirationWarned = 0
 	for _, file := range upload.Files {
 		file.Sanitize()
 	}
 }
 
+var ttlUnits = map[byte]int{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+
+// ParseTTL parses a ttl in seconds or a duration made of numbers with
+// a unit ( 90m, 12h, 2w, 1d12h ). -1 means no expiration and 0 the default ttl.
+func ParseTTL(value string) (ttl int, err error) {
+	value = strings.TrimSpace(value)
+	if value == "-1" {
+		return -1, nil
+	}
+
+	// The total is computed in 64 bits to be checked
+	// before it overflows the int of 32 bits builds
+	var total int64
+	rest := value
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		n, errNumber := strconv.ParseInt(rest[:i], 10, 64)
+		if errNumber != nil || n > math.MaxInt32 {
+			return 0, fmt.Errorf("Invalid ttl %s", value)
+		}
+
+		// A number without unit is only valid alone in seconds
+		unit := 1
+		if i < len(rest) {
+			var ok bool
+			if unit, ok = ttlUnits[rest[i]]; !ok {
+				return 0, fmt.Errorf("Invalid ttl %s : unknown unit %c ( s, m, h, d, w )", value, rest[i])
+			}
+			i++
+		} else if rest != value {
+			return 0, fmt.Errorf("Invalid ttl %s : missing unit after %s", value, rest)
+		}
+
+		total += n * int64(unit)
+		if total > math.MaxInt32 {
+			return 0, fmt.Errorf("Invalid ttl %s : too long", value)
+		}
+		rest = rest[i:]
+	}
+	if value == "" {
+		return 0, fmt.Errorf("Invalid empty ttl")
+	}
+	return int(total), nil
+}
+
+// InvalidTTLError is the error of a json ttl
+// that is neither a number nor a valid duration
+type InvalidTTLError struct {
+	err error
+}
+
+func (e *InvalidTTLError) Error() string {
+	return e.err.Error()
+}
+
+// UnmarshalTTL decodes a json ttl in seconds or as a duration string
+func UnmarshalTTL(data json.RawMessage) (ttl int, err error) {
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return 0, &InvalidTTLError{err}
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if value != math.Trunc(value) || math.Abs(value) > math.MaxInt32 {
+			return 0, &InvalidTTLError{fmt.Errorf("Invalid ttl %s", data)}
+		}
+		return int(value), nil
+	case string:
+		if ttl, err = ParseTTL(value); err != nil {
+			return 0, &InvalidTTLError{err}
+		}
+		return
+	}
+	return 0, &InvalidTTLError{fmt.Errorf("Invalid ttl %s", data)}
+}
+
 // GenerateRandomID generates a random string with specified length.
 // Used to generate upload id, tokens, ...
 func GenerateRandomID(length int) string {
@@ -0,0 +1,166 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings are named as in HTTP Content-Encoding headers
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// IsSupported tells if the encoding is one of the
+// encodings that can be used to compress files
+func IsSupported(encoding string) bool {
+	return encoding == Gzip || encoding == Brotli || encoding == Zstd
+}
+
+// NewWriter returns a writer that compresses data to w with the given encoding.
+// Close must be called to flush data, it does not close w.
+func NewWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Brotli:
+		return brotli.NewWriter(w), nil
+	case Zstd:
+		zstdWriter, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return zstdWriter, nil
+	default:
+		return nil, fmt.Errorf("Invalid encoding %s", encoding)
+	}
+}
+
+// NewReader returns a reader that decompresses data from r
+// compressed with the given encoding. An empty encoding means
+// that data is not compressed, r is then returned as is.
+// Closing the returned reader closes r.
+func NewReader(encoding string, r io.ReadCloser) (io.ReadCloser, error) {
+	var reader io.Reader
+	switch encoding {
+	case "":
+		return r, nil
+	case Gzip:
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	case Brotli:
+		reader = brotli.NewReader(r)
+	case Zstd:
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zstdReader, close: func() error {
+			zstdReader.Close()
+			return r.Close()
+		}}, nil
+	default:
+		return nil, fmt.Errorf("Invalid encoding %s", encoding)
+	}
+	return &readCloser{Reader: reader, close: r.Close}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc *readCloser) Close() error {
+	return rc.close()
+}
+
+// Negotiate returns the encoding of the list the client prefers according
+// to the Accept-Encoding request header. On equal preference the first
+// encoding of the list wins. An empty string is returned when the client
+// does not accept any of them.
+func Negotiate(acceptEncoding string, encodings []string) (encoding string) {
+	accepted := parseAcceptEncoding(acceptEncoding)
+
+	var best float64
+	for _, e := range encodings {
+		q, ok := accepted[e]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > best {
+			encoding = e
+			best = q
+		}
+	}
+	return
+}
+
+// Accepts tells if the client accepts the encoding
+// according to the Accept-Encoding request header
+func Accepts(acceptEncoding string, encoding string) bool {
+	return Negotiate(acceptEncoding, []string{encoding}) != ""
+}
+
+// parseAcceptEncoding returns the quality value of each
+// coding of the header ( gzip;q=0.8, br, *;q=0 )
+func parseAcceptEncoding(header string) (accepted map[string]float64) {
+	accepted = make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+	return
+}
@@ -71,5 +71,10 @@ func Initialize() {
 		default:
 			common.Log().Fatalf("Invalid data backend %s", common.Config.DataBackend)
 		}
+
+		// Store identical files only once
+		if common.Config.DedupEnabled {
+			dataBackend = NewDedupBackend(dataBackend)
+		}
 	}
 }
@@ -0,0 +1,198 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015> Copyright holders list can be found in AUTHORS file
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package dataBackend
+
+import (
+	"io"
+
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/metadataBackend"
+)
+
+// DedupBackend stores files on top of another data backend as blobs
+// shared by all the files with the same content. The content hash is
+// computed while the file is uploaded so the data is first saved as a
+// new blob, which is dropped if an identical blob already exists.
+// Files without hash (previews, ...) get a blob of their own.
+type DedupBackend struct {
+	backend DataBackend
+}
+
+// NewDedupBackend wraps the data backend passed as argument
+func NewDedupBackend(backend DataBackend) (db *DedupBackend) {
+	db = new(DedupBackend)
+	db.backend = backend
+	return
+}
+
+// GetFile implementation for dedup data backend reads the blob of the file
+func (db *DedupBackend) GetFile(ctx *common.PlikContext, upload *common.Upload, id string) (rc io.ReadCloser, err error) {
+	file := upload.Files[id]
+	if file == nil || file.BlobID == "" {
+		// File saved before deduplication was enabled
+		return db.backend.GetFile(ctx, upload, id)
+	}
+
+	return db.backend.GetFile(ctx, blobUpload(file.BlobID, file.BackendDetails), file.BlobID)
+}
+
+// AddFile implementation for dedup data backend saves the file
+// data as a new blob and references the blob in the metadata backend
+func (db *DedupBackend) AddFile(ctx *common.PlikContext, upload *common.Upload, file *common.File, fileReader io.Reader) (backendDetails map[string]interface{}, err error) {
+	defer ctx.Finalize(err)
+
+	blob := new(common.Blob)
+	blob.ID = common.GenerateRandomID(16)
+	u := blobUpload(blob.ID, nil)
+	u.Files[blob.ID].Name = file.Name
+
+	blob.BackendDetails, err = db.backend.AddFile(ctx.Fork("save blob"), u, u.Files[blob.ID], fileReader)
+	if err != nil {
+		// Nothing would ever remove a partially saved blob
+		db.backend.RemoveUpload(ctx.Fork("remove blob"), u)
+		return
+	}
+	file.BlobID = blob.ID
+
+	// The hash is only known once the whole file has been read
+	if file.Sha256 == "" {
+		return blob.BackendDetails, nil
+	}
+	blob.Hash = blobHash(file)
+
+	ref, err := metadataBackend.GetMetaDataBackend().AddBlobReference(ctx.Fork("add blob reference"), blob)
+	if err != nil {
+		db.backend.RemoveUpload(ctx.Fork("remove blob"), blobUpload(blob.ID, blob.BackendDetails))
+		file.BlobID = ""
+		return
+	}
+
+	// Drop the new blob if the same data was already stored
+	if ref.ID != blob.ID {
+		ctx.Infof("File is a duplicate of blob %s", ref.ID)
+		if err := db.backend.RemoveUpload(ctx.Fork("remove duplicate blob"), blobUpload(blob.ID, blob.BackendDetails)); err != nil {
+			ctx.Warningf("Unable to remove duplicate blob %s : %s", blob.ID, err)
+		}
+		file.BlobID = ref.ID
+	}
+
+	return ref.BackendDetails, nil
+}
+
+// RemoveFile implementation for dedup data backend releases the blob of the file
+func (db *DedupBackend) RemoveFile(ctx *common.PlikContext, upload *common.Upload, id string) (err error) {
+	file := upload.Files[id]
+	if file == nil || file.BlobID == "" {
+		return db.backend.RemoveFile(ctx, upload, id)
+	}
+
+	return db.releaseBlob(ctx, file)
+}
+
+// RemoveUpload implementation for dedup data backend releases the blobs
+// of the upload files then removes what's left of the upload
+func (db *DedupBackend) RemoveUpload(ctx *common.PlikContext, upload *common.Upload) (err error) {
+	defer ctx.Finalize(err)
+
+	// Files stored in the upload itself are handled by the data backend
+	u := *upload
+	u.Files = make(map[string]*common.File)
+
+	for id, file := range upload.Files {
+		// Removed and downloaded OneShot files have already released their blobs
+		if file.BlobID != "" && (file.Status == "removed" || file.Status == "downloaded") {
+			continue
+		}
+
+		if file.Preview != nil && file.Preview.BlobID != "" {
+			if e := db.releaseBlob(ctx.Fork("release preview blob"), file.Preview); e != nil {
+				err = e
+			}
+		}
+
+		if file.BlobID != "" {
+			if e := db.releaseBlob(ctx.Fork("release blob"), file); e != nil {
+				err = e
+			}
+			continue
+		}
+
+		f := *file
+		if f.Preview != nil && f.Preview.BlobID != "" {
+			f.Preview = nil
+		}
+		u.Files[id] = &f
+	}
+
+	if e := db.backend.RemoveUpload(ctx.Fork("remove upload"), &u); e != nil {
+		err = e
+	}
+
+	return
+}
+
+// releaseBlob removes a reference to the blob of the file
+// and deletes the blob when nothing references it anymore
+func (db *DedupBackend) releaseBlob(ctx *common.PlikContext, file *common.File) (err error) {
+	if file.Sha256 != "" {
+		var ref *common.Blob
+		ref, err = metadataBackend.GetMetaDataBackend().RemoveBlobReference(ctx.Fork("remove blob reference"), blobHash(file))
+		if err != nil {
+			return
+		}
+		if ref.References > 0 {
+			return
+		}
+	}
+
+	return db.backend.RemoveUpload(ctx.Fork("remove blob"), blobUpload(file.BlobID, file.BackendDetails))
+}
+
+// blobHash identifies the stored data. Files compressed at
+// rest with different encodings can't share the same blob.
+func blobHash(file *common.File) string {
+	if file.Encoding != "" {
+		return file.Sha256 + "." + file.Encoding
+	}
+	return file.Sha256
+}
+
+// blobUpload returns the upload data backends
+// expect to find the blob as its only file
+func blobUpload(id string, backendDetails map[string]interface{}) *common.Upload {
+	file := new(common.File)
+	file.ID = id
+	file.BackendDetails = backendDetails
+
+	upload := new(common.Upload)
+	upload.ID = id
+	upload.Files = map[string]*common.File{id: file}
+	return upload
+}
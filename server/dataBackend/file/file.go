@@ -99,7 +99,10 @@ func (fb *Backend) AddFile(ctx *common.PlikContext, upload *common.Upload, file
 	// Copy file data from the client request body
 	// to the file system
 	_, err = io.Copy(out, fileReader)
+	out.Close()
 	if err != nil {
+		// Don't keep the data of files rejected mid-stream ( too big, ... )
+		os.Remove(fullPath)
 		err = ctx.EWarningf("Unable to save file %s : %s", fullPath, err)
 		return
 	}
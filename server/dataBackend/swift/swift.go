@@ -92,9 +92,16 @@ func (sb *Backend) AddFile(ctx *common.PlikContext, upload *common.Upload, file
 
 	uuid := sb.getFileID(upload, file.ID)
 	object, err := sb.connection.ObjectCreate(sb.config.Container, uuid, true, "", "", nil)
+	if err != nil {
+		err = ctx.EWarningf("Unable to create object %s : %s", uuid, err)
+		return
+	}
 
 	_, err = io.Copy(object, fileReader)
 	if err != nil {
+		// Don't keep the data of files rejected mid-stream ( too big, ... )
+		object.Close()
+		sb.connection.ObjectDelete(sb.config.Container, uuid)
 		err = ctx.EWarningf("Unable to save object %s : %s", uuid, err)
 		return
 	}
@@ -133,12 +140,21 @@ func (sb *Backend) RemoveUpload(ctx *common.PlikContext, upload *common.Upload)
 		return
 	}
 
-	for fileID := range upload.Files {
+	for fileID, file := range upload.Files {
 		uuid := sb.getFileID(upload, fileID)
 		err = sb.connection.ObjectDelete(sb.config.Container, uuid)
 		if err != nil {
 			err = ctx.EWarningf("Unable to remove object %s : %s", uuid, err)
 		}
+
+		// Remove derived objects
+		if file.Preview != nil {
+			uuid = sb.getFileID(upload, file.Preview.ID)
+			err = sb.connection.ObjectDelete(sb.config.Container, uuid)
+			if err != nil {
+				err = ctx.EWarningf("Unable to remove object %s : %s", uuid, err)
+			}
+		}
 	}
 
 	return
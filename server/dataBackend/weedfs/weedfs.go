@@ -214,6 +214,33 @@ func (weedFs *Backend) RemoveFile(ctx *common.PlikContext, upload *common.Upload
 	// Get file metadata
 	file := upload.Files[id]
 
+	return weedFs.removeFile(ctx, file)
+}
+
+// RemoveUpload implementation for WeedFS Data Backend
+// Iterates on every file and call RemoveFile
+func (weedFs *Backend) RemoveUpload(ctx *common.PlikContext, upload *common.Upload) (err error) {
+	defer ctx.Finalize(err)
+
+	for fileID, file := range upload.Files {
+		err = weedFs.RemoveFile(ctx.Fork("remove file"), upload, fileID)
+		if err != nil {
+			return
+		}
+
+		// Remove derived objects
+		if file.Preview != nil {
+			err = weedFs.removeFile(ctx.Fork("remove preview"), file.Preview)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return nil
+}
+
+func (weedFs *Backend) removeFile(ctx *common.PlikContext, file *common.File) (err error) {
 	// Get WeedFS volume and file id from upload metadata
 	if file.BackendDetails["WeedFsVolume"] == nil {
 		err = ctx.EWarningf("Missing WeedFS volume from backend details")
@@ -260,21 +287,6 @@ func (weedFs *Backend) RemoveFile(ctx *common.PlikContext, upload *common.Upload
 	return
 }
 
-// RemoveUpload implementation for WeedFS Data Backend
-// Iterates on every file and call RemoveFile
-func (weedFs *Backend) RemoveUpload(ctx *common.PlikContext, upload *common.Upload) (err error) {
-	defer ctx.Finalize(err)
-
-	for fileID := range upload.Files {
-		err = weedFs.RemoveFile(ctx.Fork("remove file"), upload, fileID)
-		if err != nil {
-			return
-		}
-	}
-
-	return nil
-}
-
 func (weedFs *Backend) getvolumeURL(ctx *common.PlikContext, volumeID string) (URL string, err error) {
 	timer := ctx.Time("get volume url")
 	defer timer.Stop()
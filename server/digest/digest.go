@@ -0,0 +1,118 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015> Copyright holders list can be found in AUTHORS file
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Algorithms are named as in HTTP Repr-Digest headers
+const (
+	SHA256  = "sha-256"
+	BLAKE2b = "blake2b-512"
+	XXH64   = "xxh64"
+)
+
+// IsSupported tells if the algorithm is one of
+// the algorithms that can be used to hash files
+func IsSupported(algorithm string) bool {
+	return algorithm == SHA256 || algorithm == BLAKE2b || algorithm == XXH64
+}
+
+// New returns a new hash computing the digest
+// with the given algorithm or nil if it is not supported
+func New(algorithm string) hash.Hash {
+	switch algorithm {
+	case SHA256:
+		return sha256.New()
+	case BLAKE2b:
+		h, _ := blake2b.New512(nil)
+		return h
+	case XXH64:
+		return xxhash.New()
+	default:
+		return nil
+	}
+}
+
+// Parse reads the digests of a Repr-Digest header ( sha-256=:base64:, ... )
+// or of a legacy Digest header ( SHA-256=base64, ... ). Digests are returned
+// hex encoded by algorithm. Values that can't be decoded are skipped.
+func Parse(header string) (digests map[string]string) {
+	digests = make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			continue
+		}
+
+		algorithm := strings.ToLower(strings.TrimSpace(part[:i]))
+		value := strings.TrimSpace(part[i+1:])
+		value = strings.TrimSuffix(strings.TrimPrefix(value, ":"), ":")
+
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || algorithm == "" {
+			continue
+		}
+		digests[algorithm] = hex.EncodeToString(sum)
+	}
+	return
+}
+
+// Format writes hex encoded digests by algorithm as a
+// Repr-Digest header value and as a legacy Digest header value
+func Format(digests map[string]string) (reprDigest string, digest string) {
+	var algorithms []string
+	for algorithm := range digests {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	var reprDigests, legacyDigests []string
+	for _, algorithm := range algorithms {
+		sum, err := hex.DecodeString(digests[algorithm])
+		if err != nil {
+			continue
+		}
+		value := base64.StdEncoding.EncodeToString(sum)
+		reprDigests = append(reprDigests, algorithm+"=:"+value+":")
+		legacyDigests = append(legacyDigests, strings.ToUpper(algorithm)+"="+value)
+	}
+
+	return strings.Join(reprDigests, ", "), strings.Join(legacyDigests, ",")
+}
@@ -0,0 +1,298 @@
+/* The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE. */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/metadataBackend"
+)
+
+// expirationWarning is sent to the owner of an upload before its
+// expiration. It is the body of the requests to the webhook.
+type expirationWarning struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Comments  string    `json:"comments"`
+	Email     string    `json:"email,omitempty"`
+	ExpireAt  time.Time `json:"expireAt"`
+	ExtendURL string    `json:"extendUrl"`
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// extendPage asks to confirm the extension of an upload. Opening the
+// link must not extend it, mail scanners and link previews follow them.
+var extendPage = template.Must(template.New("extend").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Plik</title></head>
+<body>
+<p>Upload <a href="/#/?id={{.ID}}">{{.ID}}</a> expires at {{.ExpireAt}}.</p>
+<form method="POST"><button type="submit">Keep it until {{.ExtendTo}}</button></form>
+</body>
+</html>
+`))
+
+// ExpirationWarningRoutine periodicaly warns the owners of the
+// uploads expiring in less than ExpirationWarning hours
+func ExpirationWarningRoutine() {
+	ctx := common.RootContext().Fork("warn expiring uploads")
+
+	for {
+		// Sleep between 50 minutes and 1 hour
+		randSleep := rand.Intn(600) + 3000
+		time.Sleep(time.Duration(randSleep) * time.Second)
+
+		warnExpiringUploads(ctx)
+	}
+}
+
+// warnExpiringUploads warns the owners of the uploads expiring in
+// less than ExpirationWarning hours that have not been warned yet
+func warnExpiringUploads(ctx *common.PlikContext) {
+	before := time.Now().Add(time.Duration(common.Config.ExpirationWarning) * time.Hour).Unix()
+	childCtx := ctx.Fork("get uploads to warn")
+	childCtx.AutoDetach()
+	uploads, err := metadataBackend.GetMetaDataBackend().GetUploadsToWarn(childCtx, before)
+	if err != nil {
+		log.Warningf("Failed to get uploads to warn : %s", err)
+		return
+	}
+
+	for _, upload := range uploads {
+		ctx.SetUpload(upload.ID)
+		if common.Config.ExpirationWebhook == "" && (upload.Email == "" || common.Config.SmtpServer == "") {
+			continue
+		}
+
+		// Owners are warned again if the upload is extended
+		// and expires soon again, not if a warning failed
+		expiration := upload.Creation + int64(upload.TTL)
+		err = warnExpiration(newExpirationWarning(upload))
+		if err != nil {
+			log.Warningf("Unable to warn of the expiration of upload %s : %s", upload.ID, err)
+			continue
+		}
+		log.Infof("Warned of the expiration of upload %s", upload.ID)
+
+		childCtx = ctx.Fork("update metadata")
+		childCtx.AutoDetach()
+		err = metadataBackend.GetMetaDataBackend().SetExpirationWarned(childCtx, upload, expiration)
+		if err != nil {
+			log.Warningf("Unable to update upload metadata : %s", err)
+		}
+	}
+}
+
+func newExpirationWarning(upload *common.Upload) (warning *expirationWarning) {
+	expiration := upload.Creation + int64(upload.TTL)
+	base := strings.TrimSuffix(common.Config.PublicURL, "/")
+
+	warning = new(expirationWarning)
+	warning.ID = upload.ID
+	warning.URL = base + "/#/?id=" + upload.ID
+	warning.Comments = upload.Comments
+	warning.Email = upload.Email
+	warning.ExpireAt = time.Unix(expiration, 0).UTC()
+	warning.ExtendURL = fmt.Sprintf("%s/extend/%s/%d/%s", base, upload.ID, expiration, extendSignature(upload.ID, expiration))
+	return
+}
+
+// warnExpiration sends the warning to the webhook and to the email of
+// the upload. It fails only if the owner could not be warned at all.
+func warnExpiration(warning *expirationWarning) (err error) {
+	var failures []string
+	warned := false
+
+	if common.Config.ExpirationWebhook != "" {
+		if errWebhook := sendWebhook(warning); errWebhook != nil {
+			failures = append(failures, errWebhook.Error())
+		} else {
+			warned = true
+		}
+	}
+
+	if warning.Email != "" && common.Config.SmtpServer != "" {
+		if errEmail := sendEmail(warning); errEmail != nil {
+			failures = append(failures, errEmail.Error())
+		} else {
+			warned = true
+		}
+	}
+
+	if !warned {
+		return fmt.Errorf("%s", strings.Join(failures, ", "))
+	}
+	return
+}
+
+func sendWebhook(warning *expirationWarning) (err error) {
+	body, err := json.Marshal(warning)
+	if err != nil {
+		return
+	}
+
+	resp, err := webhookClient.Post(common.Config.ExpirationWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Unable to call webhook : %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook answered %s", resp.Status)
+	}
+	return
+}
+
+func sendEmail(warning *expirationWarning) (err error) {
+	var auth smtp.Auth
+	if common.Config.SmtpUsername != "" {
+		host, _, _ := net.SplitHostPort(common.Config.SmtpServer)
+		auth = smtp.PlainAuth("", common.Config.SmtpUsername, common.Config.SmtpPassword, host)
+	}
+
+	// The email address has been checked when the upload was created
+	message := new(bytes.Buffer)
+	fmt.Fprintf(message, "From: %s\r\n", common.Config.SmtpFrom)
+	fmt.Fprintf(message, "To: %s\r\n", warning.Email)
+	fmt.Fprintf(message, "Subject: Your upload %s expires soon\r\n", warning.ID)
+	fmt.Fprintf(message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(message, "Your upload %s will be removed at %s.\r\n\r\n", warning.URL, warning.ExpireAt.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(message, "Open this link to keep it longer :\r\n%s\r\n", warning.ExtendURL)
+
+	err = smtp.SendMail(common.Config.SmtpServer, auth, common.Config.SmtpFrom, []string{warning.Email}, message.Bytes())
+	if err != nil {
+		return fmt.Errorf("Unable to send email : %s", err)
+	}
+	return
+}
+
+// extendSignature signs the extend link of an upload for the expiration
+// date it is sent for, so it can't be used again once the upload is extended
+func extendSignature(uploadID string, expiration int64) string {
+	mac := hmac.New(sha256.New, []byte(common.Config.ExtendSecret))
+	fmt.Fprintf(mac, "%s:%d", uploadID, expiration)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// extendUploadHandler extends the upload of a signed link of an expiration
+// warning. It expires DefaultTTL seconds from now, within the MaxTTL limit.
+// GET requests only get a page to confirm the extension with a POST request.
+func extendUploadHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("extend upload handler", req)
+	defer ctx.Finalize(err)
+
+	// Get the upload id and the signed expiration date from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	ctx.SetUpload(uploadID)
+
+	expiration, err := strconv.ParseInt(vars["expiration"], 10, 64)
+	if err != nil || !hmac.Equal([]byte(vars["signature"]), []byte(extendSignature(uploadID, expiration))) {
+		ctx.Warningf("Invalid extend link signature")
+		redirect(req, resp, common.ErrInvalidSignature, "Invalid extend link", 403)
+		return
+	}
+
+	// Retrieve upload metadata
+	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, true)
+		return
+	}
+
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		redirect(req, resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	if upload.TTL <= 0 || upload.Creation+int64(upload.TTL) != expiration {
+		ctx.Warningf("Upload has already been extended")
+		redirect(req, resp, common.ErrInvalidRequest, fmt.Sprintf("Upload %s has already been extended", upload.ID), 409)
+		return
+	}
+
+	// The ttl of an upload is counted from its creation
+	ttl := int(time.Now().Unix()-upload.Creation) + common.Config.DefaultTTL
+	if common.Config.MaxTTL != 0 && ttl > common.Config.MaxTTL {
+		ttl = common.Config.MaxTTL
+	}
+	if ttl <= upload.TTL {
+		ctx.Warningf("Cannot extend ttl %d (maximum allowed is : %d)", upload.TTL, common.Config.MaxTTL)
+		redirect(req, resp, common.ErrTTLExceeded, fmt.Sprintf("Upload %s can't be extended (maximum ttl is : %d)", upload.ID, common.Config.MaxTTL), 400)
+		return
+	}
+
+	if req.Method == "GET" {
+		params := map[string]string{
+			"ID":       upload.ID,
+			"ExpireAt": upload.ExpirationDate().Format("2006-01-02 15:04 MST"),
+			"ExtendTo": time.Unix(upload.Creation+int64(ttl), 0).UTC().Format("2006-01-02 15:04 MST"),
+		}
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+		resp.Header().Set("Cache-Control", "no-store")
+		err = extendPage.Execute(resp, params)
+		if err != nil {
+			ctx.Warningf("Unable to write extend page : %s", err)
+		}
+		return
+	}
+
+	err = metadataBackend.GetMetaDataBackend().UpdateTTL(ctx.Fork("update metadata"), upload, ttl)
+	if err != nil {
+		ctx.Warningf("Unable to update upload metadata : %s", err)
+		redirect(req, resp, common.ErrInternal, fmt.Sprintf("Unable to update upload %s", upload.ID), 500)
+		return
+	}
+	upload.TTL = ttl
+
+	// Browsers are sent to the upload in the web client
+	if prefersHTML(req) {
+		http.Redirect(resp, req, "/#/?id="+upload.ID, 303)
+		return
+	}
+
+	message := fmt.Sprintf("Upload %s now expires at %s", upload.ID, upload.ExpirationDate().Format(time.RFC3339))
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(common.NewResult(message, nil).ToJSON())
+}
@@ -44,7 +44,8 @@ import (
 )
 
 var (
-	locks map[string]*sync.RWMutex
+	locks     map[string]*sync.RWMutex
+	blobsLock sync.Mutex
 )
 
 // MetadataBackend object
@@ -146,6 +147,9 @@ func (fmb *MetadataBackend) AddOrUpdateFile(ctx *common.PlikContext, upload *com
 
 	// The first thing to do is to reload the file from disk
 	upload, err = fmb.Get(ctx.Fork("reload metadata"), upload.ID)
+	if err != nil {
+		return
+	}
 
 	// Add file metadata to upload metadata
 	upload.Files[file.ID] = file
@@ -170,12 +174,14 @@ func (fmb *MetadataBackend) AddOrUpdateFile(ctx *common.PlikContext, upload *com
 		ctx.Infof("Upload directory %s successfully created", directory)
 	}
 
-	// Override metadata file
-	f, err := os.OpenFile(metadataFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
+	// Override metadata file. It is written aside then renamed
+	// so concurrent requests never read a partially written file
+	f, err := os.OpenFile(metadataFile+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
 	if err != nil {
 		err = ctx.EWarningf("Unable to create metadata file %s : %s", metadataFile, err)
 		return
 	}
+	defer f.Close()
 
 	// Print content
 	_, err = f.Write(b)
@@ -191,6 +197,12 @@ func (fmb *MetadataBackend) AddOrUpdateFile(ctx *common.PlikContext, upload *com
 		return
 	}
 
+	err = os.Rename(metadataFile+".tmp", metadataFile)
+	if err != nil {
+		err = ctx.EWarningf("Unable to replace metadata file %s : %s", metadataFile, err)
+		return
+	}
+
 	ctx.Infof("Metadata file successfully updated %s", metadataFile)
 	return
 }
@@ -205,6 +217,9 @@ func (fmb *MetadataBackend) RemoveFile(ctx *common.PlikContext, upload *common.U
 
 	// The first thing to do is to reload the file from disk
 	upload, err = fmb.Get(ctx.Fork("reload metadata"), upload.ID)
+	if err != nil {
+		return
+	}
 
 	// Remove file metadata from upload metadata
 	delete(upload.Files, file.Name)
@@ -220,12 +235,14 @@ func (fmb *MetadataBackend) RemoveFile(ctx *common.PlikContext, upload *common.U
 	directory := fmb.Config.Directory + "/" + upload.ID[:2] + "/" + upload.ID
 	metadataFile := directory + "/.config"
 
-	// Override metadata file
-	f, err := os.OpenFile(metadataFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
+	// Override metadata file. It is written aside then renamed
+	// so concurrent requests never read a partially written file
+	f, err := os.OpenFile(metadataFile+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
 	if err != nil {
 		err = ctx.EWarningf("Unable to create metadata file %s : %s", metadataFile, err)
 		return
 	}
+	defer f.Close()
 
 	// Print content
 	_, err = f.Write(b)
@@ -241,6 +258,12 @@ func (fmb *MetadataBackend) RemoveFile(ctx *common.PlikContext, upload *common.U
 		return
 	}
 
+	err = os.Rename(metadataFile+".tmp", metadataFile)
+	if err != nil {
+		err = ctx.EWarningf("Unable to replace metadata file %s : %s", metadataFile, err)
+		return
+	}
+
 	ctx.Infof("Metadata file successfully updated %s", metadataFile)
 	return nil
 }
@@ -269,6 +292,66 @@ func (fmb *MetadataBackend) Remove(ctx *common.PlikContext, upload *common.Uploa
 	return
 }
 
+// UpdateTTL implementation for File Metadata Backend
+func (fmb *MetadataBackend) UpdateTTL(ctx *common.PlikContext, upload *common.Upload, ttl int) (err error) {
+	defer ctx.Finalize(err)
+
+	// avoid race condition
+	lock(upload.ID)
+	defer unlock(upload.ID)
+
+	// The first thing to do is to reload the file from disk
+	upload, err = fmb.Get(ctx.Fork("reload metadata"), upload.ID)
+	if err != nil {
+		return
+	}
+
+	upload.TTL = ttl
+
+	// Serialize metadata to json
+	b, err := json.MarshalIndent(upload, "", "    ")
+	if err != nil {
+		err = ctx.EWarningf("Unable to serialize metadata to json : %s", err)
+		return
+	}
+
+	// Get metadata file path
+	directory := fmb.Config.Directory + "/" + upload.ID[:2] + "/" + upload.ID
+	metadataFile := directory + "/.config"
+
+	// Override metadata file. It is written aside then renamed
+	// so concurrent requests never read a partially written file
+	f, err := os.OpenFile(metadataFile+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
+	if err != nil {
+		err = ctx.EWarningf("Unable to create metadata file %s : %s", metadataFile, err)
+		return
+	}
+	defer f.Close()
+
+	// Print content
+	_, err = f.Write(b)
+	if err != nil {
+		err = ctx.EWarningf("Unable to write metadata file %s : %s", metadataFile, err)
+		return
+	}
+
+	// Sync on disk
+	err = f.Sync()
+	if err != nil {
+		err = ctx.EWarningf("Unable to sync metadata file %s : %s", metadataFile, err)
+		return
+	}
+
+	err = os.Rename(metadataFile+".tmp", metadataFile)
+	if err != nil {
+		err = ctx.EWarningf("Unable to replace metadata file %s : %s", metadataFile, err)
+		return
+	}
+
+	ctx.Infof("Metadata file successfully updated %s", metadataFile)
+	return
+}
+
 // GetUploadsToRemove implementation for File Metadata Backend
 func (fmb *MetadataBackend) GetUploadsToRemove(ctx *common.PlikContext) (ids []string, err error) {
 	defer ctx.Finalize(err)
@@ -300,6 +383,12 @@ func (fmb *MetadataBackend) GetUploadsToRemove(ctx *common.PlikContext) (ids []s
 		pathsToRemove := strings.Split(string(o), "\n")
 		for _, pathToRemove := range pathsToRemove {
 			if pathToRemove != "" {
+				// Skip directories without metadata like
+				// deduplicated blobs of the file data backend
+				if _, err := os.Stat(pathToRemove + "/.config"); err != nil {
+					continue
+				}
+
 				// Extract upload id from path
 				uploadID := filepath.Base(pathToRemove)
 				ids = append(ids, uploadID)
@@ -310,6 +399,272 @@ func (fmb *MetadataBackend) GetUploadsToRemove(ctx *common.PlikContext) (ids []s
 	return ids, nil
 }
 
+// GetUploadsToWarn implementation for File Metadata Backend
+func (fmb *MetadataBackend) GetUploadsToWarn(ctx *common.PlikContext, before int64) (uploads []*common.Upload, err error) {
+	defer ctx.Finalize(err)
+
+	// Every metadata file has to be read to know the expiration date of
+	// the uploads. This only runs when expiration warnings are enabled.
+	metadataFiles, err := filepath.Glob(fmb.Config.Directory + "/*/*/.config")
+	if err != nil {
+		err = ctx.EWarningf("Unable to list metadata files : %s", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, metadataFile := range metadataFiles {
+		buffer, errRead := ioutil.ReadFile(metadataFile)
+		if errRead != nil {
+			continue
+		}
+		upload := new(common.Upload)
+		if errRead = json.Unmarshal(buffer, upload); errRead != nil {
+			ctx.Warningf("Unable to unserialize metadata file %s : %s", metadataFile, errRead)
+			continue
+		}
+
+		if upload.TTL <= 0 {
+			continue
+		}
+		expiration := upload.Creation + int64(upload.TTL)
+		if expiration > now && expiration <= before && upload.ExpirationWarned != expiration {
+			uploads = append(uploads, upload)
+		}
+	}
+
+	return
+}
+
+// SetExpirationWarned implementation for File Metadata Backend
+func (fmb *MetadataBackend) SetExpirationWarned(ctx *common.PlikContext, upload *common.Upload, expiration int64) (err error) {
+	defer ctx.Finalize(err)
+
+	// avoid race condition
+	lock(upload.ID)
+	defer unlock(upload.ID)
+
+	// The first thing to do is to reload the file from disk
+	upload, err = fmb.Get(ctx.Fork("reload metadata"), upload.ID)
+	if err != nil {
+		return
+	}
+
+	upload.ExpirationWarned = expiration
+
+	// Serialize metadata to json
+	b, err := json.MarshalIndent(upload, "", "    ")
+	if err != nil {
+		err = ctx.EWarningf("Unable to serialize metadata to json : %s", err)
+		return
+	}
+
+	// Get metadata file path
+	directory := fmb.Config.Directory + "/" + upload.ID[:2] + "/" + upload.ID
+	metadataFile := directory + "/.config"
+
+	// Override metadata file. It is written aside then renamed
+	// so concurrent requests never read a partially written file
+	err = ioutil.WriteFile(metadataFile+".tmp", b, os.FileMode(0666))
+	if err != nil {
+		err = ctx.EWarningf("Unable to write metadata file %s : %s", metadataFile, err)
+		return
+	}
+
+	err = os.Rename(metadataFile+".tmp", metadataFile)
+	if err != nil {
+		err = ctx.EWarningf("Unable to replace metadata file %s : %s", metadataFile, err)
+		return
+	}
+
+	return
+}
+
+// CreateTombstone implementation for File Metadata Backend
+func (fmb *MetadataBackend) CreateTombstone(ctx *common.PlikContext, tombstone *common.Tombstone) (err error) {
+	defer ctx.Finalize(err)
+
+	b, err := json.MarshalIndent(tombstone, "", "    ")
+	if err != nil {
+		err = ctx.EWarningf("Unable to serialize tombstone to json : %s", err)
+		return
+	}
+
+	err = os.MkdirAll(fmb.Config.Directory+"/.tombstones", 0777)
+	if err != nil {
+		err = ctx.EWarningf("Unable to create tombstones directory : %s", err)
+		return
+	}
+
+	err = ioutil.WriteFile(fmb.getTombstonePath(tombstone.ID), b, os.FileMode(0666))
+	if err != nil {
+		err = ctx.EWarningf("Unable to write tombstone of upload %s : %s", tombstone.ID, err)
+		return
+	}
+
+	return
+}
+
+// GetTombstone implementation for File Metadata Backend
+func (fmb *MetadataBackend) GetTombstone(ctx *common.PlikContext, id string) (tombstone *common.Tombstone, err error) {
+	defer ctx.Finalize(err)
+
+	// Ids come from urls, they must not escape the tombstones directory
+	if strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		err = ctx.EWarningf("Invalid upload id %s", id)
+		return
+	}
+
+	buffer, err := ioutil.ReadFile(fmb.getTombstonePath(id))
+	if err != nil {
+		return
+	}
+
+	tombstone = new(common.Tombstone)
+	if err = json.Unmarshal(buffer, tombstone); err != nil {
+		err = ctx.EWarningf("Unable to unserialize tombstone of upload %s : %s", id, err)
+		return
+	}
+
+	return
+}
+
+// RemoveTombstones implementation for File Metadata Backend
+func (fmb *MetadataBackend) RemoveTombstones(ctx *common.PlikContext, before int64) (err error) {
+	defer ctx.Finalize(err)
+
+	infos, err := ioutil.ReadDir(fmb.Config.Directory + "/.tombstones")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		err = ctx.EWarningf("Unable to list tombstones : %s", err)
+		return
+	}
+
+	for _, info := range infos {
+		tombstone := new(common.Tombstone)
+		buffer, errRead := ioutil.ReadFile(fmb.getTombstonePath(info.Name()))
+		if errRead == nil {
+			errRead = json.Unmarshal(buffer, tombstone)
+		}
+		if errRead != nil || tombstone.Expiration >= before {
+			continue
+		}
+		if errRemove := os.Remove(fmb.getTombstonePath(info.Name())); errRemove != nil {
+			ctx.Warningf("Unable to remove tombstone of upload %s : %s", info.Name(), errRemove)
+		}
+	}
+
+	return
+}
+
+// AddBlobReference implementation for File Metadata Backend
+func (fmb *MetadataBackend) AddBlobReference(ctx *common.PlikContext, blob *common.Blob) (ref *common.Blob, err error) {
+	defer ctx.Finalize(err)
+
+	blobsLock.Lock()
+	defer blobsLock.Unlock()
+
+	// Reference the existing blob or register the new one
+	ref, err = fmb.getBlob(blob.Hash)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			err = ctx.EWarningf("Unable to read blob %s : %s", blob.Hash, err)
+			return
+		}
+		ref = blob
+		ref.References = 0
+	}
+	ref.References++
+
+	err = fmb.saveBlob(ref)
+	if err != nil {
+		err = ctx.EWarningf("Unable to save blob %s : %s", blob.Hash, err)
+		return
+	}
+
+	ctx.Infof("Blob %s has now %d references", ref.Hash, ref.References)
+	return
+}
+
+// RemoveBlobReference implementation for File Metadata Backend
+func (fmb *MetadataBackend) RemoveBlobReference(ctx *common.PlikContext, hash string) (ref *common.Blob, err error) {
+	defer ctx.Finalize(err)
+
+	blobsLock.Lock()
+	defer blobsLock.Unlock()
+
+	ref, err = fmb.getBlob(hash)
+	if err != nil {
+		err = ctx.EWarningf("Unable to read blob %s : %s", hash, err)
+		return
+	}
+	ref.References--
+
+	// Forget the blob with its last reference
+	if ref.References <= 0 {
+		err = os.Remove(fmb.getBlobPath(hash))
+		if err != nil {
+			err = ctx.EWarningf("Unable to remove blob %s : %s", hash, err)
+			return
+		}
+		ctx.Infof("Blob %s is not referenced anymore", hash)
+		return
+	}
+
+	err = fmb.saveBlob(ref)
+	if err != nil {
+		err = ctx.EWarningf("Unable to save blob %s : %s", hash, err)
+		return
+	}
+
+	ctx.Infof("Blob %s has now %d references", ref.Hash, ref.References)
+	return
+}
+
+// Blobs are saved in a flat directory next to the upload
+// directories so they are never mistaken for uploads.
+func (fmb *MetadataBackend) getBlobPath(hash string) string {
+	return fmb.Config.Directory + "/.blobs/" + hash
+}
+
+// Tombstones are saved in a flat directory like blobs
+func (fmb *MetadataBackend) getTombstonePath(id string) string {
+	return fmb.Config.Directory + "/.tombstones/" + id
+}
+
+func (fmb *MetadataBackend) getBlob(hash string) (blob *common.Blob, err error) {
+	buffer, err := ioutil.ReadFile(fmb.getBlobPath(hash))
+	if err != nil {
+		return
+	}
+
+	blob = new(common.Blob)
+	err = json.Unmarshal(buffer, blob)
+	return
+}
+
+func (fmb *MetadataBackend) saveBlob(blob *common.Blob) (err error) {
+	b, err := json.MarshalIndent(blob, "", "    ")
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(fmb.Config.Directory+"/.blobs", 0777)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so a crash
+	// never leaves a truncated reference count
+	path := fmb.getBlobPath(blob.Hash)
+	err = ioutil.WriteFile(path+".tmp", b, os.FileMode(0666))
+	if err != nil {
+		return
+	}
+	return os.Rename(path+".tmp", path)
+}
+
 // /!\ There is a race condition to avoid /!\
 // If a client add/remove many files of the same upload
 // in parallel the associated metadata file
@@ -45,7 +45,15 @@ type MetadataBackend interface {
 	AddOrUpdateFile(ctx *common.PlikContext, u *common.Upload, file *common.File) (err error)
 	RemoveFile(ctx *common.PlikContext, u *common.Upload, file *common.File) (err error)
 	Remove(ctx *common.PlikContext, u *common.Upload) (err error)
+	UpdateTTL(ctx *common.PlikContext, u *common.Upload, ttl int) (err error)
 	GetUploadsToRemove(ctx *common.PlikContext) (ids []string, err error)
+	GetUploadsToWarn(ctx *common.PlikContext, before int64) (uploads []*common.Upload, err error)
+	SetExpirationWarned(ctx *common.PlikContext, u *common.Upload, expiration int64) (err error)
+	CreateTombstone(ctx *common.PlikContext, tombstone *common.Tombstone) (err error)
+	GetTombstone(ctx *common.PlikContext, id string) (tombstone *common.Tombstone, err error)
+	RemoveTombstones(ctx *common.PlikContext, before int64) (err error)
+	AddBlobReference(ctx *common.PlikContext, blob *common.Blob) (ref *common.Blob, err error)
+	RemoveBlobReference(ctx *common.PlikContext, hash string) (ref *common.Blob, err error)
 }
 
 // GetMetaDataBackend is a singleton pattern.
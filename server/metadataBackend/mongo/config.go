@@ -35,12 +35,14 @@ import (
 
 // MetadataBackendConfig object
 type MetadataBackendConfig struct {
-	URL        string
-	Database   string
-	Collection string
-	Username   string
-	Password   string
-	Ssl        bool
+	URL                 string
+	Database            string
+	Collection          string
+	BlobCollection      string
+	TombstoneCollection string
+	Username            string
+	Password            string
+	Ssl                 bool
 }
 
 // NewMongoMetadataBackendConfig configures the backend
@@ -50,6 +52,8 @@ func NewMongoMetadataBackendConfig(config map[string]interface{}) (mmb *Metadata
 	mmb.URL = "127.0.0.1:27017"
 	mmb.Database = "plik"
 	mmb.Collection = "meta"
+	mmb.BlobCollection = "blobs"
+	mmb.TombstoneCollection = "tombstones"
 	utils.Assign(mmb, config)
 	return
 }
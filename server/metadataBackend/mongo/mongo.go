@@ -148,6 +148,19 @@ func (mmb *MetadataBackend) Remove(ctx *common.PlikContext, upload *common.Uploa
 	return
 }
 
+// UpdateTTL implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) UpdateTTL(ctx *common.PlikContext, upload *common.Upload, ttl int) (err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.Collection)
+	err = collection.Update(bson.M{"id": upload.ID}, bson.M{"$set": bson.M{"ttl": ttl}})
+	if err != nil {
+		err = ctx.EWarningf("Unable to update ttl in mongodb : %s", err)
+	}
+	return
+}
+
 // GetUploadsToRemove implementation from MongoDB Metadata Backend
 func (mmb *MetadataBackend) GetUploadsToRemove(ctx *common.PlikContext) (ids []string, err error) {
 	defer ctx.Finalize(err)
@@ -172,3 +185,135 @@ func (mmb *MetadataBackend) GetUploadsToRemove(ctx *common.PlikContext) (ids []s
 
 	return
 }
+
+// GetUploadsToWarn implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) GetUploadsToWarn(ctx *common.PlikContext, before int64) (uploads []*common.Upload, err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.Collection)
+
+	// Look for uploads expiring before the date whose owner has
+	// not been warned yet of this expiration date
+	now := strconv.Itoa(int(time.Now().Unix()))
+	b := bson.M{"$where": "this.ttl > 0 && this.uploadDate+this.ttl > " + now + " && this.uploadDate+this.ttl <= " + strconv.Itoa(int(before)) + " && this.expirationWarned != this.uploadDate+this.ttl"}
+
+	err = collection.Find(b).All(&uploads)
+	if err != nil {
+		err = ctx.EWarningf("Unable to get uploads to warn : %s", err)
+	}
+	return
+}
+
+// SetExpirationWarned implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) SetExpirationWarned(ctx *common.PlikContext, upload *common.Upload, expiration int64) (err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.Collection)
+	err = collection.Update(bson.M{"id": upload.ID}, bson.M{"$set": bson.M{"expirationWarned": expiration}})
+	if err != nil {
+		err = ctx.EWarningf("Unable to update expiration warning in mongodb : %s", err)
+	}
+	return
+}
+
+// CreateTombstone implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) CreateTombstone(ctx *common.PlikContext, tombstone *common.Tombstone) (err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.TombstoneCollection)
+	_, err = collection.Upsert(bson.M{"id": tombstone.ID}, tombstone)
+	if err != nil {
+		err = ctx.EWarningf("Unable to create tombstone in mongodb : %s", err)
+	}
+	return
+}
+
+// GetTombstone implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) GetTombstone(ctx *common.PlikContext, id string) (tombstone *common.Tombstone, err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.TombstoneCollection)
+	tombstone = &common.Tombstone{}
+	err = collection.Find(bson.M{"id": id}).One(tombstone)
+	if err != nil && err != mgo.ErrNotFound {
+		err = ctx.EWarningf("Unable to get tombstone from mongodb : %s", err)
+	}
+	return
+}
+
+// RemoveTombstones implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) RemoveTombstones(ctx *common.PlikContext, before int64) (err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.TombstoneCollection)
+	_, err = collection.RemoveAll(bson.M{"expireDate": bson.M{"$lt": before}})
+	if err != nil {
+		err = ctx.EWarningf("Unable to remove tombstones from mongodb : %s", err)
+	}
+	return
+}
+
+// AddBlobReference implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) AddBlobReference(ctx *common.PlikContext, blob *common.Blob) (ref *common.Blob, err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.BlobCollection)
+
+	// Blobs are indexed by hash ( _id ) so two concurrent inserts of the same
+	// blob can't both succeed, the loser references the winner's blob instead
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"references": 1}}, ReturnNew: true}
+	for i := 0; i < 2; i++ {
+		ref = &common.Blob{}
+		_, err = collection.FindId(blob.Hash).Apply(change, ref)
+		if err != mgo.ErrNotFound {
+			break
+		}
+
+		blob.References = 1
+		err = collection.Insert(blob)
+		if !mgo.IsDup(err) {
+			ref = blob
+			break
+		}
+	}
+	if err != nil {
+		err = ctx.EWarningf("Unable to add blob reference to mongodb : %s", err)
+	}
+	return
+}
+
+// RemoveBlobReference implementation from MongoDB Metadata Backend
+func (mmb *MetadataBackend) RemoveBlobReference(ctx *common.PlikContext, hash string) (ref *common.Blob, err error) {
+	defer ctx.Finalize(err)
+	session := mmb.session.Copy()
+	defer session.Close()
+	collection := session.DB(mmb.config.Database).C(mmb.config.BlobCollection)
+
+	ref = &common.Blob{}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"references": -1}}, ReturnNew: true}
+	_, err = collection.FindId(hash).Apply(change, ref)
+	if err != nil {
+		err = ctx.EWarningf("Unable to remove blob reference from mongodb : %s", err)
+		return
+	}
+
+	// Forget the blob with its last reference unless
+	// it has been referenced again in the meantime
+	if ref.References <= 0 {
+		err = collection.Remove(bson.M{"_id": hash, "references": bson.M{"$lte": 0}})
+		if err == mgo.ErrNotFound {
+			ref.References = 1
+			err = nil
+		}
+		if err != nil {
+			err = ctx.EWarningf("Unable to remove blob from mongodb : %s", err)
+		}
+	}
+	return
+}
@@ -0,0 +1,333 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Document is an OpenAPI 3.0 document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       *Info                `json:"info"`
+	Servers    []*Server            `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server is the base url of the paths
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem holds the operations of a path by lower case http method
+type PathItem map[string]*Operation
+
+// Operation is an http method of a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body of the requests of an operation
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body for a content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas referenced by the operations
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is the JSON schema of a value. AdditionalProperties
+// is either false or the *Schema of the values of a map.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// NewDocument returns an empty document
+func NewDocument(title string, version string) (doc *Document) {
+	doc = new(Document)
+	doc.OpenAPI = "3.0.3"
+	doc.Info = &Info{Title: title, Version: version}
+	doc.Paths = make(map[string]*PathItem)
+	doc.Components = &Components{Schemas: make(map[string]*Schema)}
+	return
+}
+
+// AddOperation adds the operation to the path
+func (doc *Document) AddOperation(path string, method string, operation *Operation) {
+	item, ok := doc.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		doc.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = operation
+}
+
+// SchemaOf returns the schema of the json encoding of the value. Structs
+// are added to the components of the document and referenced by name.
+func (doc *Document) SchemaOf(value interface{}) *Schema {
+	return doc.schemaOf(reflect.TypeOf(value))
+}
+
+func (doc *Document) schemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return doc.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: doc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return doc.structSchema(t)
+		}
+		// The schema is registered before its fields for recursive types
+		if _, ok := doc.Components.Schemas[t.Name()]; !ok {
+			doc.Components.Schemas[t.Name()] = &Schema{}
+			*doc.Components.Schemas[t.Name()] = *doc.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	// Interfaces can hold any value
+	return &Schema{}
+}
+
+func (doc *Document) structSchema(t reflect.Type) (schema *Schema) {
+	schema = &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if parts := strings.Split(tag, ","); parts[0] != "" {
+				name = parts[0]
+			}
+		}
+		schema.Properties[name] = doc.schemaOf(field.Type)
+
+		// Fields decoded from several json types list them in an
+		// openapi tag ( openapi:"integer|string" )
+		if tag := field.Tag.Get("openapi"); tag != "" {
+			alternatives := &Schema{}
+			for _, typeName := range strings.Split(tag, "|") {
+				alternatives.OneOf = append(alternatives.OneOf, &Schema{Type: typeName})
+			}
+			schema.Properties[name] = alternatives
+		}
+	}
+	return
+}
+
+// Validate checks that the value decoded from json
+// ( encoding/json.Unmarshal into an interface{} ) matches the schema
+func (doc *Document) Validate(schema *Schema, value interface{}) error {
+	return doc.validate(schema, value, "")
+}
+
+func (doc *Document) validate(schema *Schema, value interface{}, path string) (err error) {
+	if schema.Ref != "" {
+		referenced, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("Unknown schema %s", schema.Ref)
+		}
+		schema = referenced
+	}
+
+	// Null is the zero value of every go type
+	if value == nil {
+		return
+	}
+
+	if schema.OneOf != nil {
+		var expected []string
+		for _, alternative := range schema.OneOf {
+			if doc.validate(alternative, value, path) == nil {
+				return
+			}
+			expected = append(expected, alternative.Type)
+		}
+		return invalid(path, strings.Join(expected, " or "), value)
+	}
+
+	if schema.Type == "" {
+		return
+	}
+
+	switch schema.Type {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid(path, "a boolean", value)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return invalid(path, "an integer", value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return invalid(path, "a number", value)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return invalid(path, "a string", value)
+		}
+		if schema.Format == "date-time" {
+			if _, errDate := time.Parse(time.RFC3339, str); errDate != nil {
+				return invalid(path, "a RFC3339 date", value)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return invalid(path, "an array", value)
+		}
+		for i, item := range items {
+			if err = doc.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid(path, "an object", value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("Missing %s", join(path, name))
+			}
+		}
+
+		// Sorted to always report the same error
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				additional, isSchema := schema.AdditionalProperties.(*Schema)
+				if !isSchema {
+					if schema.AdditionalProperties == false {
+						return fmt.Errorf("Unknown property %s", join(path, name))
+					}
+					continue
+				}
+				property = additional
+			}
+			if err = doc.validate(property, object[name], join(path, name)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func invalid(path string, expected string, value interface{}) error {
+	if path == "" {
+		return fmt.Errorf("Invalid value %v : expected %s", value, expected)
+	}
+	return fmt.Errorf("Invalid value %v for %s : expected %s", value, path, expected)
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
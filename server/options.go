@@ -0,0 +1,168 @@
+/* The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/root-gg/plik/server/common"
+)
+
+// uploadOption is an option of the upload that can also be set with a
+// query parameter or a X-Plik-* header, for curl one-liners and raw uploads
+type uploadOption struct {
+	name        string // name of the query parameter and of the json field
+	header      string
+	description string
+	field       func(upload *common.Upload) interface{}
+}
+
+var uploadOptions = []*uploadOption{
+	{name: "ttl", header: "X-Plik-TTL", description: "Time to live in seconds or with a unit ( 30m, 12h, 7d ), -1 for no expiration", field: func(upload *common.Upload) interface{} { return &upload.TTL }},
+	{name: "oneShot", header: "X-Plik-OneShot", description: "Files are removed after their first download", field: func(upload *common.Upload) interface{} { return &upload.OneShot }},
+	{name: "removable", header: "X-Plik-Removable", description: "Files can be removed by anyone", field: func(upload *common.Upload) interface{} { return &upload.Removable }},
+	{name: "expireAt", header: "X-Plik-ExpireAt", description: "Expiration date of the upload ( RFC3339 ) instead of a ttl", field: func(upload *common.Upload) interface{} { return &upload.ExpireAt }},
+	{name: "comments", header: "X-Plik-Comments", description: "Comments of the upload", field: func(upload *common.Upload) interface{} { return &upload.Comments }},
+	{name: "email", header: "X-Plik-Email", description: "Email of the owner warned before the expiration", field: func(upload *common.Upload) interface{} { return &upload.Email }},
+	{name: "login", header: "X-Plik-Login", description: "Login of the upload protected by a password", field: func(upload *common.Upload) interface{} { return &upload.Login }},
+	{name: "password", header: "X-Plik-Password", description: "Password of the upload", field: func(upload *common.Upload) interface{} { return &upload.Password }},
+	{name: "yubikey", header: "X-Plik-Yubikey", description: "Yubikey OTP to protect the upload", field: func(upload *common.Upload) interface{} { return &upload.Yubikey }},
+}
+
+// applyUploadOptions sets the options of the upload given as query parameters
+// or X-Plik-* headers. body is the json body the upload has been read from.
+// An option given several times with different values, in query parameters
+// of any case, in headers or in the json body, is an error. The
+// error response is written if an option is invalid.
+func applyUploadOptions(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, upload *common.Upload, body []byte) (err error) {
+	var fields map[string]json.RawMessage
+	if len(body) > 0 {
+		json.Unmarshal(body, &fields)
+	}
+	query := req.URL.Query()
+
+	for _, option := range uploadOptions {
+		// Query parameter names are case insensitive, sorted to
+		// report conflicts the same way whatever the map order
+		var names []string
+		for name := range query {
+			if strings.EqualFold(name, option.name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		var value, source string
+		var values, sources []string
+		for _, name := range names {
+			for _, v := range query[name] {
+				values, sources = append(values, v), append(sources, "query parameter "+name)
+			}
+		}
+		for _, v := range req.Header[http.CanonicalHeaderKey(option.header)] {
+			values, sources = append(values, v), append(sources, option.header+" header")
+		}
+		for i := range values {
+			if values[i] != values[0] {
+				err = ctx.EWarningf("Conflicting values for %s : %s and %s", option.name, sources[0], sources[i])
+				writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Conflicting values for %s : %q in %s and %q in %s", option.name, values[0], sources[0], values[i], sources[i]), 400)
+				return
+			}
+		}
+		if len(values) > 0 {
+			value, source = values[0], sources[0]
+		}
+		if source == "" {
+			continue
+		}
+
+		parsed := common.NewUpload()
+		err = parseUploadOption(option.field(parsed), value)
+		if err != nil {
+			code := common.ErrInvalidRequest
+			if option.name == "ttl" || option.name == "expireAt" {
+				code = common.ErrInvalidTTL
+			}
+			ctx.Warningf("Invalid %s in %s : %s", option.name, source, err)
+			writeError(resp, code, fmt.Sprintf("Invalid %s in %s : %s", option.name, source, err), 400)
+			return
+		}
+
+		// Json field names are case insensitive
+		for name := range fields {
+			if strings.EqualFold(name, option.name) && optionValue(option.field(upload)) != optionValue(option.field(parsed)) {
+				err = ctx.EWarningf("Conflicting values for %s : json body and %s", option.name, source)
+				writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Conflicting values for %s : %v in the json body and %q in %s", option.name, optionValue(option.field(upload)), value, source), 400)
+				return
+			}
+		}
+
+		parseUploadOption(option.field(upload), value)
+	}
+	return
+}
+
+// parseUploadOption parses the value of an option into the field of the upload
+func parseUploadOption(field interface{}, value string) (err error) {
+	switch field := field.(type) {
+	case *int:
+		*field, err = common.ParseTTL(value)
+	case *bool:
+		// A flag without value is set ( ?oneShot )
+		*field = true
+		if value != "" {
+			*field, err = strconv.ParseBool(value)
+		}
+	case *string:
+		*field = value
+	case **time.Time:
+		var date time.Time
+		date, err = time.Parse(time.RFC3339, value)
+		*field = &date
+	}
+	return
+}
+
+func optionValue(field interface{}) interface{} {
+	switch field := field.(type) {
+	case *int:
+		return *field
+	case *bool:
+		return *field
+	case *string:
+		return *field
+	case **time.Time:
+		if *field != nil {
+			return (*field).Unix()
+		}
+	}
+	return nil
+}
@@ -32,14 +32,19 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -47,14 +52,21 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/root-gg/logger"
 	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/compression"
 	"github.com/root-gg/plik/server/dataBackend"
+	"github.com/root-gg/plik/server/digest"
 	"github.com/root-gg/plik/server/metadataBackend"
+	"github.com/root-gg/plik/server/preview"
 	"github.com/root-gg/plik/server/shortenBackend"
 	"github.com/root-gg/utils"
 )
 
 var log *logger.Logger
 
+// multipartOverhead is the size allowed for the multipart boundaries
+// and the other parts of a request adding a file ( path, size, ... )
+var multipartOverhead int64 = 65536 // 64KB
+
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -78,19 +90,13 @@ func main() {
 	shortenBackend.Initialize()
 
 	// HTTP Api routes configuration
-	r := mux.NewRouter()
-	r.HandleFunc("/upload", createUploadHandler).Methods("POST")
-	r.HandleFunc("/upload/{uploadID}", getUploadHandler).Methods("GET")
-	r.HandleFunc("/upload/{uploadID}/file", addFileHandler).Methods("POST")
-	r.HandleFunc("/upload/{uploadID}/file/{fileID}", getFileHandler).Methods("GET")
-	r.HandleFunc("/upload/{uploadID}/file/{fileID}", removeFileHandler).Methods("DELETE")
-	r.HandleFunc("/file/{uploadID}/{fileID}/{filename}", getFileHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/file/{uploadID}/{fileID}/{filename}/yubikey/{yubikey}", getFileHandler).Methods("GET")
-	r.PathPrefix("/clients/").Handler(http.StripPrefix("/clients/", http.FileServer(http.Dir("../clients"))))
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./public/")))
+	r := newRouter()
 	http.Handle("/", r)
 
 	go UploadsCleaningRoutine()
+	if common.Config.ExpirationWarning > 0 && (common.Config.ExpirationWebhook != "" || common.Config.SmtpServer != "") {
+		go ExpirationWarningRoutine()
+	}
 
 	// Start HTTP server
 	go func() {
@@ -121,6 +127,46 @@ func main() {
 	}
 }
 
+// newRouter returns the router of the api, download and web client routes
+func newRouter() (r *mux.Router) {
+	r = mux.NewRouter()
+	registerAPI(r)
+
+	// Legacy api routes, replaced by the /api/v1 routes
+	r.HandleFunc("/upload", deprecated(createUploadHandler)).Methods("POST")
+	r.HandleFunc("/upload/{uploadID}", deprecated(getUploadHandler)).Methods("GET")
+	r.HandleFunc("/upload/{uploadID}", deprecated(removeUploadHandler)).Methods("DELETE")
+	r.HandleFunc("/upload/{uploadID}/ttl", deprecated(updateTTLHandler)).Methods("POST")
+	r.HandleFunc("/upload/{uploadID}/file", deprecated(addFileHandler)).Methods("POST")
+	r.HandleFunc("/upload/{uploadID}/file/{fileID}", deprecated(getFileHandler)).Methods("GET")
+	r.HandleFunc("/upload/{uploadID}/file/{fileID}", deprecated(removeFileHandler)).Methods("DELETE")
+	r.HandleFunc("/file/{uploadID}/{fileID}/{filename}/yubikey/{yubikey}", deprecated(getFileHandler)).Methods("GET")
+	r.HandleFunc("/archive/{uploadID}/{filename}/yubikey/{yubikey}", deprecated(getArchiveHandler)).Methods("GET")
+	r.HandleFunc("/preview/{uploadID}/{fileID}/yubikey/{yubikey}", deprecated(getPreviewHandler)).Methods("GET")
+
+	// Server configuration
+	r.HandleFunc("/config", getConfigHandler).Methods("GET")
+	r.HandleFunc("/version", getVersionHandler).Methods("GET")
+
+	// Signed links of the expiration warnings
+	r.HandleFunc("/extend/{uploadID}/{expiration}/{signature}", extendUploadHandler).Methods("GET", "POST")
+
+	// Raw uploads ( curl -T file.txt http://plik/ or curl --data-binary @- http://plik/ )
+	r.HandleFunc("/put/{filename}", putFileHandler).Methods("PUT")
+	r.HandleFunc("/{filename}", putFileHandler).Methods("PUT")
+	r.HandleFunc("/", putFileHandler).Methods("POST")
+
+	// Short download links
+	r.HandleFunc("/file/{uploadID}/{fileID}/{filename}", getFileHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/tree/{uploadID}", getTreeHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/tree/{uploadID}/{path:.*}", getTreeHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/archive/{uploadID}/{filename}", getArchiveHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/preview/{uploadID}/{fileID}", getPreviewHandler).Methods("GET", "HEAD")
+	r.PathPrefix("/clients/").Handler(http.StripPrefix("/clients/", http.FileServer(http.Dir("../clients"))))
+	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./public/")))
+	return
+}
+
 /*
  * HTTP HANDLERS
  */
@@ -133,14 +179,36 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 	upload := common.NewUpload()
 	ctx.SetUpload(upload.ID)
 
-	// Read request body
+	// Read request body. Files can be sent with the upload in a multipart
+	// request, the json body is then in a first "upload" part.
 	defer req.Body.Close()
-	req.Body = http.MaxBytesReader(resp, req.Body, 1048576)
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		ctx.Warningf("Unable to read request body : %s", err)
-		http.Error(resp, common.NewResult("Unable to read request body", nil).ToJSONString(), 500)
-		return
+	var body []byte
+	var part *multipart.Part
+	multiPartReader, err := req.MultipartReader()
+	if err == nil {
+		part, err = multiPartReader.NextPart()
+		if err == nil && part.FormName() == "upload" {
+			body, err = ioutil.ReadAll(io.LimitReader(part, 1048576))
+			if err == nil {
+				part, err = multiPartReader.NextPart()
+			}
+		}
+		if err == io.EOF {
+			part, err = nil, nil
+		}
+		if err != nil {
+			ctx.Warningf("Unable to read multipart request : %s", err)
+			writeError(resp, common.ErrInvalidRequest, "Unable to read multipart request", 400)
+			return
+		}
+	} else {
+		req.Body = http.MaxBytesReader(resp, req.Body, 1048576)
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			ctx.Warningf("Unable to read request body : %s", err)
+			writeError(resp, common.ErrInternal, "Unable to read request body", 500)
+			return
+		}
 	}
 
 	// Deserialize json body
@@ -148,16 +216,85 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 		err = json.Unmarshal(body, upload)
 		if err != nil {
 			ctx.Warningf("Unable to deserialize request body : %s", err)
-			http.Error(resp, common.NewResult("Unable to deserialize json request body", nil).ToJSONString(), 500)
+			if _, ok := err.(*common.InvalidTTLError); ok {
+				writeError(resp, common.ErrInvalidTTL, err.Error(), 400)
+				return
+			}
+			writeError(resp, common.ErrInvalidRequest, "Unable to deserialize json request body", 400)
+			return
+		}
+	}
+
+	// Options can also be given as query parameters or X-Plik-* headers
+	err = applyUploadOptions(ctx, req, resp, upload, body)
+	if err != nil {
+		return
+	}
+
+	// Check the options and save the new upload
+	err = saveNewUpload(ctx, req, resp, upload)
+	if err != nil {
+		return
+	}
+	uploadToken := upload.UploadToken
+
+	// Save the files sent with the upload, the upload is
+	// not created if one of them can't be saved
+	if part != nil {
+		var files []*common.File
+		var expected map[*common.File]map[string]string
+		files, expected, err = saveFiles(ctx, req, resp, upload, multiPartReader, part, 0)
+		if err == nil {
+			err = addFiles(ctx, req, resp, upload, files, expected)
+		}
+		if err != nil {
+			if err := metadataBackend.GetMetaDataBackend().Remove(ctx.Fork("remove metadata"), upload); err != nil {
+				ctx.Warningf("Unable to remove upload : %s", err)
+			}
 			return
 		}
 	}
 
+	// Remove all private informations (ip, data backend details, ...) before
+	// sending metadata back to the client
+	upload.Sanitize()
+
+	// Show upload token since its an upload creation
+	upload.UploadToken = uploadToken
+
+	// Print upload metadata in the json response.
+	var json []byte
+	if json, err = utils.ToJson(upload); err != nil {
+		ctx.Warningf("Unable to serialize response body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+	}
+
+	resp.Write(json)
+}
+
+// saveNewUpload checks the options of a new upload and saves it in the
+// metadata backend. The error response is written if it can't be created.
+func saveNewUpload(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, upload *common.Upload) (err error) {
+
 	// Set upload id, creation date, upload token, ...
 	upload.Create()
 	ctx.SetUpload(upload.ID)
 	upload.RemoteIP = req.RemoteAddr
-	uploadToken := upload.UploadToken
+
+	// An expiration date is the ttl from the creation of the upload
+	if upload.ExpireAt != nil {
+		if upload.TTL != 0 {
+			err = ctx.EWarningf("Got both a ttl and an expiration date")
+			writeError(resp, common.ErrInvalidTTL, "Cannot set both ttl and expireAt", 400)
+			return
+		}
+		if !upload.ExpireAt.After(time.Unix(upload.Creation, 0)) {
+			err = ctx.EWarningf("Expiration date %s is in the past", upload.ExpireAt)
+			writeError(resp, common.ErrInvalidTTL, fmt.Sprintf("Expiration date %s is in the past", upload.ExpireAt.Format(time.RFC3339)), 400)
+			return
+		}
+		upload.TTL = int(upload.ExpireAt.Unix() - upload.Creation)
+	}
 
 	// TTL = Time in second before the upload expiration
 	// 0 	-> No ttl specified : default value from configuration
@@ -167,23 +304,34 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 		upload.TTL = common.Config.DefaultTTL
 	case -1:
 		if common.Config.MaxTTL != 0 {
-			ctx.Warningf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL)
-			http.Error(resp, common.NewResult(fmt.Sprintf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL), nil).ToJSONString(), 400)
+			err = ctx.EWarningf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL)
+			writeError(resp, common.ErrTTLExceeded, fmt.Sprintf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL), 400)
 			return
 		}
 	default:
 		if upload.TTL < 0 {
-			ctx.Warningf("Invalid value for ttl : %d", upload.TTL)
-			http.Error(resp, common.NewResult(fmt.Sprintf("Invalid value for ttl : %d", upload.TTL), nil).ToJSONString(), 400)
+			err = ctx.EWarningf("Invalid value for ttl : %d", upload.TTL)
+			writeError(resp, common.ErrInvalidTTL, fmt.Sprintf("Invalid value for ttl : %d", upload.TTL), 400)
 			return
 		}
 		if common.Config.MaxTTL != 0 && upload.TTL > common.Config.MaxTTL {
-			ctx.Warningf("Cannot set ttl to %d (maximum allowed is : %d)", upload.TTL, common.Config.MaxTTL)
-			http.Error(resp, common.NewResult(fmt.Sprintf("Cannot set ttl to %d (maximum allowed is : %d)", upload.TTL, common.Config.MaxTTL), nil).ToJSONString(), 400)
+			err = ctx.EWarningf("Cannot set ttl to %d (maximum allowed is : %d)", upload.TTL, common.Config.MaxTTL)
+			writeError(resp, common.ErrTTLExceeded, fmt.Sprintf("Cannot set ttl to %d (maximum allowed is : %d)", upload.TTL, common.Config.MaxTTL), 400)
 			return
 		}
 	}
 
+	// The owner is warned at this address before the expiration
+	if upload.Email != "" {
+		address, errAddress := mail.ParseAddress(upload.Email)
+		if errAddress != nil {
+			err = ctx.EWarningf("Invalid email %s : %s", upload.Email, errAddress)
+			writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Invalid email %s", upload.Email), 400)
+			return
+		}
+		upload.Email = address.Address
+	}
+
 	// Protect upload with HTTP basic auth
 	// Add Authorization header to the response for convenience
 	// So clients can just copy this header into the next request
@@ -199,7 +347,7 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 		upload.Password, err = utils.Md5sum(b64str)
 		if err != nil {
 			ctx.Warningf("Unable to generate password hash : %s", err)
-			http.Error(resp, common.NewResult("Unable to generate password hash", nil).ToJSONString(), 500)
+			writeError(resp, common.ErrInternal, "Unable to generate password hash", 500)
 			return
 		}
 		resp.Header().Add("Authorization", "Basic "+b64str)
@@ -213,21 +361,22 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 		upload.ProtectedByYubikey = true
 
 		if !common.Config.YubikeyEnabled {
-			ctx.Warningf("Got a Yubikey upload but Yubikey backend is disabled")
-			http.Error(resp, common.NewResult("Yubikey are disabled on this server", nil).ToJSONString(), 500)
+			err = ctx.EWarningf("Got a Yubikey upload but Yubikey backend is disabled")
+			writeError(resp, common.ErrYubikeyDisabled, "Yubikey are disabled on this server", 400)
 			return
 		}
 
-		_, ok, err := common.Config.YubiAuth.Verify(upload.Yubikey)
+		var ok bool
+		_, ok, err = common.Config.YubiAuth.Verify(upload.Yubikey)
 		if err != nil {
 			ctx.Warningf("Unable to validate yubikey token : %s", err)
-			http.Error(resp, common.NewResult("Unable to validate yubikey token", nil).ToJSONString(), 500)
+			writeError(resp, common.ErrInternal, "Unable to validate yubikey token", 500)
 			return
 		}
 
 		if !ok {
-			ctx.Warningf("Invalid yubikey token")
-			http.Error(resp, common.NewResult("Invalid yubikey token", nil).ToJSONString(), 401)
+			err = ctx.EWarningf("Invalid yubikey token")
+			writeError(resp, common.ErrYubikeyInvalid, "Invalid yubikey token", 401)
 			return
 		}
 
@@ -257,24 +406,77 @@ func createUploadHandler(resp http.ResponseWriter, req *http.Request) {
 	err = metadataBackend.GetMetaDataBackend().Create(ctx.Fork("create metadata"), upload)
 	if err != nil {
 		ctx.Warningf("Create new upload error : %s", err)
-		http.Error(resp, common.NewResult("Unable to create new upload", nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, "Unable to create new upload", 500)
 		return
 	}
+	return
+}
 
-	// Remove all private informations (ip, data backend details, ...) before
-	// sending metadata back to the client
-	upload.Sanitize()
+func putFileHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("put file handler", req)
+	defer ctx.Finalize(err)
 
-	// Show upload token since its an upload creation
+	// Reject files too big before reading the request body. Clients
+	// waiting for a 100 Continue response don't even send it.
+	if req.ContentLength > int64(common.Config.MaxFileSize) {
+		ctx.Warningf("Request body too big : %d bytes", req.ContentLength)
+		writeError(resp, common.ErrFileTooBig, fmt.Sprintf("File is too big (limit is set to %d bytes)", common.Config.MaxFileSize), 413)
+		return
+	}
+
+	// The file name is the last element of the url ( curl -T file.txt http://plik/ )
+	fileName := mux.Vars(req)["filename"]
+	if fileName == "" {
+		fileName = "file"
+	}
+
+	// Create the upload and save the request body as its only file
+	upload := common.NewUpload()
+	err = applyUploadOptions(ctx, req, resp, upload, nil)
+	if err != nil {
+		return
+	}
+	err = saveNewUpload(ctx, req, resp, upload)
+	if err != nil {
+		return
+	}
+	uploadToken := upload.UploadToken
+
+	algorithms := append([]string{digest.SHA256}, common.Config.ExtraDigests...)
+	for algorithm := range expectedDigests(req) {
+		algorithms = append(algorithms, algorithm)
+	}
+	file, err := saveFile(ctx, resp, upload, nil, req.Body, fileName, "", "", algorithms)
+	if err == nil {
+		err = addFiles(ctx, req, resp, upload, []*common.File{file}, map[*common.File]map[string]string{file: {}})
+	}
+	if err != nil {
+		if err := metadataBackend.GetMetaDataBackend().Remove(ctx.Fork("remove metadata"), upload); err != nil {
+			ctx.Warningf("Unable to remove upload : %s", err)
+		}
+		return
+	}
+
+	// Reply with the download url or with the upload and
+	// its upload token for JSON clients
+	URL := fileURL(req, upload, file)
+	if acceptQuality(req, "application/json") <= acceptQuality(req, "text/plain") {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte(URL + "\n"))
+		return
+	}
+
+	upload.Sanitize()
 	upload.UploadToken = uploadToken
 
-	// Print upload metadata in the json response.
 	var json []byte
-	if json, err = utils.ToJson(upload); err != nil {
+	if json, err = utils.ToJson(&common.UploadResult{URL: URL, Upload: upload}); err != nil {
 		ctx.Warningf("Unable to serialize response body : %s", err)
-		http.Error(resp, common.NewResult("Unable to serialize response body", nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+		return
 	}
-
+	resp.Header().Set("Content-Type", "application/json")
 	resp.Write(json)
 }
 
@@ -292,7 +494,7 @@ func getUploadHandler(resp http.ResponseWriter, req *http.Request) {
 	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
 	if err != nil {
 		ctx.Warningf("Upload %s not found : %s", uploadID, err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Upload %s not found", uploadID), nil).ToJSONString(), 404)
+		uploadNotFound(ctx, req, resp, uploadID, false)
 		return
 	}
 
@@ -313,38 +515,26 @@ func getUploadHandler(resp http.ResponseWriter, req *http.Request) {
 	var json []byte
 	if json, err = utils.ToJson(upload); err != nil {
 		ctx.Warningf("Unable to serialize response body : %s", err)
-		http.Error(resp, common.NewResult("Unable to serialize response body", nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
 	}
 	resp.Write(json)
 }
 
-func getFileHandler(resp http.ResponseWriter, req *http.Request) {
+func removeUploadHandler(resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx := common.NewPlikContext("get file handler", req)
+	ctx := common.NewPlikContext("remove upload handler", req)
 	defer ctx.Finalize(err)
 
-	// Get the upload id and file id from the url params
+	// Get the upload id from the url params
 	vars := mux.Vars(req)
 	uploadID := vars["uploadID"]
-	fileID := vars["fileID"]
-	fileName := vars["filename"]
-	if uploadID == "" {
-		ctx.Warning("Missing upload id")
-		redirect(req, resp, errors.New("Missing upload id"), 404)
-		return
-	}
-	if fileID == "" {
-		ctx.Warning("Missing file id")
-		redirect(req, resp, errors.New("Missing file id"), 404)
-		return
-	}
 	ctx.SetUpload(uploadID)
 
-	// Get the upload informations from the metadata backend
+	// Retrieve upload metadata
 	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
 	if err != nil {
 		ctx.Warningf("Upload %s not found : %s", uploadID, err)
-		redirect(req, resp, fmt.Errorf("Upload %s not found", uploadID), 404)
+		uploadNotFound(ctx, req, resp, uploadID, false)
 		return
 	}
 
@@ -355,149 +545,35 @@ func getFileHandler(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Test if upload is not expired
-	if upload.TTL != 0 {
-		if time.Now().Unix() >= (upload.Creation + int64(upload.TTL)) {
-			ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
-			redirect(req, resp, fmt.Errorf("Upload %s is expired", upload.ID), 404)
-			return
-		}
-	}
-
-	// Retrieve file using data backend
-	if _, ok := upload.Files[fileID]; !ok {
-		ctx.Warningf("File %s not found", fileID)
-		redirect(req, resp, fmt.Errorf("File %s not found", fileID), 404)
-		return
-	}
-
-	file := upload.Files[fileID]
-	ctx.SetFile(file.Name)
-
-	// Compare url filename with upload filename
-	if file.Name != fileName {
-		ctx.Warningf("Invalid filename %s mismatch %s", fileName, file.Name)
-		redirect(req, resp, fmt.Errorf("File %s not found", fileName), 404)
+	// Only the uploader can remove the upload
+	if req.Header.Get("X-UploadToken") != upload.UploadToken {
+		ctx.Warningf("Invalid upload token %s", req.Header.Get("X-UploadToken"))
+		writeError(resp, common.ErrUploadTokenInvalid, "Invalid upload token in X-UploadToken header", 403)
 		return
 	}
 
-	// If upload has OneShot option, test if file has not been already downloaded once
-	if upload.OneShot && file.Status == "downloaded" {
-		ctx.Warningf("File %s has already been downloaded in upload %s", file.Name, upload.ID)
-		redirect(req, resp, fmt.Errorf("File %s has already been downloaded", file.Name), 401)
+	// Remove from data backend
+	err = dataBackend.GetDataBackend().RemoveUpload(ctx.Fork("remove upload data"), upload)
+	if err != nil {
+		ctx.Warningf("Unable to remove upload data : %s", err)
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Unable to remove upload %s", upload.ID), 500)
 		return
 	}
 
-	// If the file is marked as deleted by a previous call, we abort request
-	if upload.Removable && file.Status == "removed" {
-		ctx.Warningf("File %s has been removed", file.Name)
-		redirect(req, resp, fmt.Errorf("File %s has been removed", file.Name), 404)
+	// Remove from metadata backend
+	err = metadataBackend.GetMetaDataBackend().Remove(ctx.Fork("remove upload metadata"), upload)
+	if err != nil {
+		ctx.Warningf("Unable to remove upload metadata : %s", err)
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Unable to remove upload %s", upload.ID), 500)
 		return
 	}
 
-	// Check yubikey
-	// If upload is yubikey protected, user must send an OTP when he wants to get a file.
-	if upload.Yubikey != "" {
-		token := vars["yubikey"]
-		if token == "" {
-			ctx.Warningf("Missing yubikey token")
-			redirect(req, resp, errors.New("Invalid yubikey token"), 401)
-			return
-		}
-		if len(token) != 44 {
-			ctx.Warningf("Invalid yubikey token : %s", token)
-			redirect(req, resp, errors.New("Invalid yubikey token"), 401)
-			return
-		}
-		if token[:12] != upload.Yubikey {
-			ctx.Warningf("Invalid yubikey device : %s", token)
-			redirect(req, resp, errors.New("Invalid yubikey token"), 401)
-			return
-		}
-
-		// Error if yubikey is disabled on server, and enabled on upload
-		if !common.Config.YubikeyEnabled {
-			ctx.Warningf("Got a Yubikey upload but Yubikey backend is disabled")
-			redirect(req, resp, errors.New("Yubikey are disabled on this server"), 500)
-			return
-		}
-
-		_, isValid, err := common.Config.YubiAuth.Verify(token)
-		if err != nil {
-			ctx.Warningf("Failed to validate yubikey token : %s", err)
-			redirect(req, resp, errors.New("Invalid yubikey token"), 401)
-			return
-		}
-		if !isValid {
-			ctx.Warningf("Invalid yubikey token : %s", token)
-			redirect(req, resp, errors.New("Invalid yubikey token"), 401)
-			return
-		}
-	}
-
-	// Set content type and print file
-	resp.Header().Set("Content-Type", file.Type)
-	resp.Header().Set("Content-Length", strconv.Itoa(int(file.CurrentSize)))
-
-	// If "dl" GET params is set
-	// -> Set Content-Disposition header
-	// -> The client should download file instead of displaying it
-	dl := req.URL.Query().Get("dl")
-	if dl != "" {
-		resp.Header().Set("Content-Disposition", "attachement; filename="+file.Name)
-	} else {
-		resp.Header().Set("Content-Disposition", "filename="+file.Name)
-	}
-
-	// HEAD Request => Do not print file, user just wants http headers
-	// GET  Request => Print file content
-	ctx.Infof("Got a %s request", req.Method)
-
-	if req.Method == "GET" {
-		// Get file in data backend
-		fileReader, err := dataBackend.GetDataBackend().GetFile(ctx.Fork("get file"), upload, file.ID)
-		if err != nil {
-			ctx.Warningf("Failed to get file %s in upload %s : %s", file.Name, upload.ID, err)
-			redirect(req, resp, fmt.Errorf("Failed to read file %s", file.Name), 404)
-			return
-		}
-		defer fileReader.Close()
-
-		// Update metadata if oneShot option is set
-		if upload.OneShot {
-			file.Status = "downloaded"
-			err = metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, file)
-			if err != nil {
-				ctx.Warningf("Error while deleting file %s from upload %s metadata : %s", file.Name, upload.ID, err)
-			}
-		}
-
-		// File is piped directly to http response body without buffering
-		_, err = io.Copy(resp, fileReader)
-		if err != nil {
-			ctx.Warningf("Error while copying file to response : %s", err)
-		}
-
-		// Remove file from data backend if oneShot option is set
-		if upload.OneShot {
-			err = dataBackend.GetDataBackend().RemoveFile(ctx.Fork("remove file"), upload, file.ID)
-			if err != nil {
-				ctx.Warningf("Error while deleting file %s from upload %s : %s", file.Name, upload.ID, err)
-				return
-			}
-		}
-
-		// Remove upload if no files are available
-		err = RemoveUploadIfNoFileAvailable(ctx, upload)
-		if err != nil {
-			ctx.Warningf("Error while checking if upload can be removed : %s", err)
-		}
-	}
+	resp.Write([]byte(common.NewResult(fmt.Sprintf("Upload %s removed", upload.ID), nil).ToJSONString()))
 }
 
-func addFileHandler(resp http.ResponseWriter, req *http.Request) {
+func updateTTLHandler(resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx := common.NewPlikContext("add file handler", req)
+	ctx := common.NewPlikContext("update ttl handler", req)
 	defer ctx.Finalize(err)
 
 	// Get the upload id from the url params
@@ -505,11 +581,11 @@ func addFileHandler(resp http.ResponseWriter, req *http.Request) {
 	uploadID := vars["uploadID"]
 	ctx.SetUpload(uploadID)
 
-	// Get upload metadata
+	// Retrieve upload metadata
 	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
 	if err != nil {
-		ctx.Warningf("Upload metadata not found")
-		http.Error(resp, common.NewResult(fmt.Sprintf("Upload %s not found", uploadID), nil).ToJSONString(), 404)
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, false)
 		return
 	}
 
@@ -520,100 +596,1116 @@ func addFileHandler(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Check upload token
+	// Only the uploader can change the expiration of the upload
 	if req.Header.Get("X-UploadToken") != upload.UploadToken {
 		ctx.Warningf("Invalid upload token %s", req.Header.Get("X-UploadToken"))
-		http.Error(resp, common.NewResult("Invalid upload token in X-UploadToken header", nil).ToJSONString(), 404)
+		writeError(resp, common.ErrUploadTokenInvalid, "Invalid upload token in X-UploadToken header", 403)
 		return
 	}
 
-	// Get file handle from multipart request
-	var file io.Reader
-	var fileName string
-	multiPartReader, err := req.MultipartReader()
+	// Expired uploads can't be brought back
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		writeError(resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	// Read request body
+	defer req.Body.Close()
+	req.Body = http.MaxBytesReader(resp, req.Body, 1048576)
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		ctx.Warningf("Failed to get file from multipart request : %s", err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Failed to get file from multipart request"), nil).ToJSONString(), 500)
+		ctx.Warningf("Unable to read request body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to read request body", 500)
 		return
 	}
 
-	// Read multipart body until the "file" part
-	for {
-		part, errPart := multiPartReader.NextPart()
-		if errPart == io.EOF {
-			break
+	// The new ttl is the time in second before the expiration from now
+	// or the expiration date
+	//  0 -> default value from configuration
+	// -1 -> No expiration : checking with configuration if that's ok
+	params := new(ttlParams)
+	err = json.Unmarshal(body, params)
+	if err != nil {
+		ctx.Warningf("Unable to deserialize request body : %s", err)
+		if _, ok := err.(*common.InvalidTTLError); ok {
+			writeError(resp, common.ErrInvalidTTL, err.Error(), 400)
+			return
 		}
+		writeError(resp, common.ErrInvalidRequest, "Unable to deserialize json request body", 400)
+		return
+	}
 
-		if part.FormName() == "file" {
-			file = part
-			fileName = part.FileName()
-			break
+	ttl := params.TTL
+	if params.ExpireAt != nil {
+		if ttl != 0 {
+			ctx.Warningf("Got both a ttl and an expiration date")
+			writeError(resp, common.ErrInvalidTTL, "Cannot set both ttl and expireAt", 400)
+			return
+		}
+		ttl = int(time.Until(*params.ExpireAt).Seconds())
+		if ttl <= 0 {
+			ctx.Warningf("Expiration date %s is in the past", params.ExpireAt)
+			writeError(resp, common.ErrInvalidTTL, fmt.Sprintf("Expiration date %s is in the past", params.ExpireAt.Format(time.RFC3339)), 400)
+			return
+		}
+	}
+
+	switch {
+	case ttl == -1:
+		if common.Config.MaxTTL != 0 {
+			ctx.Warningf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL)
+			writeError(resp, common.ErrTTLExceeded, fmt.Sprintf("Cannot set infinite ttl (maximum allowed is : %d)", common.Config.MaxTTL), 400)
+			return
+		}
+	case ttl < 0:
+		ctx.Warningf("Invalid value for ttl : %d", ttl)
+		writeError(resp, common.ErrInvalidTTL, fmt.Sprintf("Invalid value for ttl : %d", ttl), 400)
+		return
+	default:
+		if ttl == 0 {
+			ttl = common.Config.DefaultTTL
+		}
+
+		// The ttl of an upload is counted from its creation
+		ttl += int(time.Now().Unix() - upload.Creation)
+		if common.Config.MaxTTL != 0 && ttl > common.Config.MaxTTL {
+			ctx.Warningf("Cannot set ttl to %d (maximum allowed is : %d)", ttl, common.Config.MaxTTL)
+			writeError(resp, common.ErrTTLExceeded, fmt.Sprintf("Cannot set ttl to %d since the upload creation (maximum allowed is : %d)", ttl, common.Config.MaxTTL), 400)
+			return
+		}
+	}
+
+	err = metadataBackend.GetMetaDataBackend().UpdateTTL(ctx.Fork("update metadata"), upload, ttl)
+	if err != nil {
+		ctx.Warningf("Unable to update upload metadata : %s", err)
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Unable to update upload %s", upload.ID), 500)
+		return
+	}
+	upload.TTL = ttl
+
+	// Remove all private informations (ip, data backend details, ...) before
+	// sending metadata back to the client
+	upload.Sanitize()
+
+	// Print upload metadata in the json response.
+	var json []byte
+	if json, err = utils.ToJson(upload); err != nil {
+		ctx.Warningf("Unable to serialize response body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+		return
+	}
+	resp.Write(json)
+}
+
+func getFileHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("get file handler", req)
+	defer ctx.Finalize(err)
+
+	// Files must not be served from the web interface domain
+	if redirectToDownloadDomain(req, resp) {
+		return
+	}
+
+	// Get the upload id and file id from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	fileID := vars["fileID"]
+	fileName := vars["filename"]
+	if uploadID == "" {
+		ctx.Warning("Missing upload id")
+		redirect(req, resp, common.ErrInvalidRequest, "Missing upload id", 400)
+		return
+	}
+	if fileID == "" {
+		ctx.Warning("Missing file id")
+		redirect(req, resp, common.ErrInvalidRequest, "Missing file id", 400)
+		return
+	}
+	ctx.SetUpload(uploadID)
+
+	// Get the upload informations from the metadata backend
+	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, true)
+		return
+	}
+
+	// Handle basic auth if upload is password protected
+	err = httpBasicAuth(req, resp, upload)
+	if err != nil {
+		ctx.Warningf("Unauthorized : %s", err)
+		return
+	}
+
+	// Test if upload is not expired
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		redirect(req, resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	// Retrieve file using data backend
+	if _, ok := upload.Files[fileID]; !ok {
+		ctx.Warningf("File %s not found", fileID)
+		redirect(req, resp, common.ErrFileNotFound, fmt.Sprintf("File %s not found", fileID), 404)
+		return
+	}
+
+	file := upload.Files[fileID]
+	ctx.SetFile(file.Name)
+
+	// Compare url filename with upload filename
+	if file.Name != fileName {
+		ctx.Warningf("Invalid filename %s mismatch %s", fileName, file.Name)
+		redirect(req, resp, common.ErrFileNotFound, fmt.Sprintf("File %s not found", fileName), 404)
+		return
+	}
+
+	serveFile(ctx, resp, req, upload, file)
+}
+
+// getTreeHandler serves the file at a path of the tree of an upload
+// or lists the files of a directory of the tree
+func getTreeHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("get tree handler", req)
+	defer ctx.Finalize(err)
+
+	// Files must not be served from the web interface domain
+	if redirectToDownloadDomain(req, resp) {
+		return
+	}
+
+	// Get the upload id and the path from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	treePath := strings.Trim(vars["path"], "/")
+	ctx.SetUpload(uploadID)
+
+	// Get the upload informations from the metadata backend
+	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, true)
+		return
+	}
+
+	// Handle basic auth if upload is password protected
+	err = httpBasicAuth(req, resp, upload)
+	if err != nil {
+		ctx.Warningf("Unauthorized : %s", err)
+		return
+	}
+
+	// Test if upload is not expired
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		redirect(req, resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	// Serve the file if the path is the one of a file. A removed
+	// file may have the same path than the one uploaded after it.
+	var file *common.File
+	var files []*common.File
+	for _, f := range upload.Files {
+		if f.FullPath() == treePath {
+			if file == nil || f.Status == "uploaded" {
+				file = f
+			}
+		} else if treePath == "" || strings.HasPrefix(f.FullPath(), treePath+"/") {
+			files = append(files, f)
+		}
+	}
+	if file != nil {
+		ctx.SetFile(file.Name)
+		serveFile(ctx, resp, req, upload, file)
+		return
+	}
+
+	// Otherwise list the files of the directory
+	if len(files) == 0 {
+		ctx.Warningf("Path %s not found", treePath)
+		redirect(req, resp, common.ErrPathNotFound, fmt.Sprintf("Path %s not found", treePath), 404)
+		return
+	}
+	sort.Sort(filesByName(files))
+	for _, f := range files {
+		f.Sanitize()
+	}
+
+	var json []byte
+	if json, err = utils.ToJson(files); err != nil {
+		ctx.Warningf("Unable to serialize response body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	if req.Method == "GET" {
+		resp.Write(json)
+	}
+}
+
+// serveFile sends the content of a file of the upload
+func serveFile(ctx *common.PlikContext, resp http.ResponseWriter, req *http.Request, upload *common.Upload, file *common.File) {
+	var err error
+
+	// If upload has OneShot option, test if file has not been already downloaded once
+	if upload.OneShot && file.Status == "downloaded" {
+		ctx.Warningf("File %s has already been downloaded in upload %s", file.Name, upload.ID)
+		redirect(req, resp, common.ErrFileUnavailable, fmt.Sprintf("File %s has already been downloaded", file.Name), 404)
+		return
+	}
+
+	// If the file is marked as deleted by a previous call, we abort request
+	if upload.Removable && file.Status == "removed" {
+		ctx.Warningf("File %s has been removed", file.Name)
+		redirect(req, resp, common.ErrFileUnavailable, fmt.Sprintf("File %s has been removed", file.Name), 404)
+		return
+	}
+
+	// Check yubikey
+	// If upload is yubikey protected, user must send an OTP when he wants to get a file.
+	err = checkYubikey(ctx, req, resp, upload)
+	if err != nil {
+		return
+	}
+
+	// Choose the encoding of the response body
+	//  - Files stored compressed are sent as is to clients accepting this encoding
+	//  - Compressible files are compressed on the fly with the client preferred encoding
+	//  - Everything else is sent uncompressed
+	acceptEncoding := req.Header.Get("Accept-Encoding")
+	compressible := common.Config.CompressionEnabled && common.MatchContentType(file.Type, common.Config.CompressibleContentTypes)
+	var encoding string
+	if file.Encoding != "" && compression.Accepts(acceptEncoding, file.Encoding) {
+		encoding = file.Encoding
+	} else if compressible {
+		encoding = compression.Negotiate(acceptEncoding, common.Config.CompressionEncodings)
+	}
+
+	// Set content type and print file
+	// Browsers must not guess another content type than the one we send
+	resp.Header().Set("Content-Type", file.Type)
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	if file.Encoding != "" || compressible {
+		resp.Header().Set("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		resp.Header().Set("Content-Encoding", encoding)
+	} else {
+		resp.Header().Set("Content-Length", strconv.Itoa(int(file.CurrentSize)))
+
+		// Digests of the file content only match uncompressed responses
+		if digests := file.Digests(); len(digests) > 0 {
+			reprDigest, legacyDigest := digest.Format(digests)
+			resp.Header().Set("Repr-Digest", reprDigest)
+			resp.Header().Set("Digest", legacyDigest)
+		}
+	}
+
+	// Uncompressed responses may be partial to resume interrupted downloads
+	status := http.StatusOK
+	offset, length := int64(0), file.CurrentSize
+	if encoding == "" {
+		resp.Header().Set("Accept-Ranges", "bytes")
+		if req.Header.Get("Range") != "" {
+			var start, end int64
+			start, end, err = parseRange(req.Header.Get("Range"), file.CurrentSize)
+			if err != nil {
+				ctx.Warningf("Invalid range %s : %s", req.Header.Get("Range"), err)
+				resp.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.CurrentSize))
+				writeError(resp, common.ErrInvalidRange, fmt.Sprintf("Invalid range : %s", err), 416)
+				return
+			}
+			if end >= 0 {
+				status = http.StatusPartialContent
+				offset, length = start, end-start+1
+				resp.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.CurrentSize))
+				resp.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			}
+		}
+	}
+
+	// If "dl" GET params is set or if the content type is not
+	// safe to render (html, svg, ...) the client should download
+	// file instead of displaying it
+	dl := req.URL.Query().Get("dl")
+	if dl != "" || !isInlineContentType(file.Type) {
+		resp.Header().Set("Content-Disposition", contentDisposition("attachment", file.Name))
+	} else {
+		resp.Header().Set("Content-Disposition", contentDisposition("inline", file.Name))
+	}
+
+	// HEAD Request => Do not print file, user just wants http headers
+	// GET  Request => Print file content
+	ctx.Infof("Got a %s request", req.Method)
+
+	if req.Method != "GET" {
+		resp.WriteHeader(status)
+	} else {
+		// Get file in data backend
+		fileReader, err := dataBackend.GetDataBackend().GetFile(ctx.Fork("get file"), upload, file.ID)
+		if err != nil {
+			ctx.Warningf("Failed to get file %s in upload %s : %s", file.Name, upload.ID, err)
+			resp.Header().Del("Content-Encoding")
+			redirect(req, resp, common.ErrInternal, fmt.Sprintf("Failed to read file %s", file.Name), 500)
+			return
+		}
+
+		// Uncompress file data if it is not sent as stored
+		if encoding != file.Encoding {
+			fileReader, err = compression.NewReader(file.Encoding, fileReader)
+			if err != nil {
+				ctx.Warningf("Failed to uncompress file %s in upload %s : %s", file.Name, upload.ID, err)
+				resp.Header().Del("Content-Encoding")
+				redirect(req, resp, common.ErrInternal, fmt.Sprintf("Failed to read file %s", file.Name), 500)
+				return
+			}
+		}
+		defer fileReader.Close()
+
+		// Update metadata if oneShot option is set
+		if upload.OneShot {
+			file.Status = "downloaded"
+			err = metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, file)
+			if err != nil {
+				ctx.Warningf("Error while deleting file %s from upload %s metadata : %s", file.Name, upload.ID, err)
+			}
+		}
+
+		// Skip the data before the requested range
+		if offset > 0 {
+			_, err = io.CopyN(ioutil.Discard, fileReader, offset)
+			if err != nil {
+				ctx.Warningf("Failed to seek file %s in upload %s : %s", file.Name, upload.ID, err)
+				redirect(req, resp, common.ErrInternal, fmt.Sprintf("Failed to read file %s", file.Name), 500)
+				return
+			}
+		}
+
+		// File is piped directly to http response body without buffering
+		resp.WriteHeader(status)
+		// Files stored compressed and sent as is are longer or shorter
+		// than their size, only uncompressed data is cut to the range
+		if encoding != "" && encoding != file.Encoding {
+			err = compressTo(resp, encoding, fileReader)
+		} else if encoding != "" {
+			_, err = io.Copy(resp, fileReader)
+		} else {
+			_, err = io.CopyN(resp, fileReader, length)
+		}
+		if err != nil {
+			ctx.Warningf("Error while copying file to response : %s", err)
+		}
+
+		// Remove file from data backend if oneShot option is set
+		if upload.OneShot {
+			err = dataBackend.GetDataBackend().RemoveFile(ctx.Fork("remove file"), upload, file.ID)
+			if err != nil {
+				ctx.Warningf("Error while deleting file %s from upload %s : %s", file.Name, upload.ID, err)
+				return
+			}
+		}
+
+		// Remove upload if no files are available
+		err = RemoveUploadIfNoFileAvailable(ctx, upload)
+		if err != nil {
+			ctx.Warningf("Error while checking if upload can be removed : %s", err)
+		}
+	}
+}
+
+func getArchiveHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("get archive handler", req)
+	defer ctx.Finalize(err)
+
+	// Files must not be served from the web interface domain
+	if redirectToDownloadDomain(req, resp) {
+		return
+	}
+
+	// Get the upload id and archive name from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	archiveName := vars["filename"]
+	if uploadID == "" {
+		ctx.Warning("Missing upload id")
+		redirect(req, resp, common.ErrInvalidRequest, "Missing upload id", 400)
+		return
+	}
+	ctx.SetUpload(uploadID)
+
+	// The archive format is given by the archive name extension
+	var archiveType string
+	switch {
+	case strings.HasSuffix(archiveName, ".zip") && len(archiveName) > len(".zip"):
+		archiveType = "zip"
+	case strings.HasSuffix(archiveName, ".tar.gz") && len(archiveName) > len(".tar.gz"):
+		archiveType = "tar.gz"
+	default:
+		ctx.Warningf("Invalid archive name %s", archiveName)
+		redirect(req, resp, common.ErrInvalidArchiveName, fmt.Sprintf("Invalid archive name %s (must end with .zip or .tar.gz)", archiveName), 400)
+		return
+	}
+
+	// Get the upload informations from the metadata backend
+	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, true)
+		return
+	}
+
+	// Handle basic auth if upload is password protected
+	err = httpBasicAuth(req, resp, upload)
+	if err != nil {
+		ctx.Warningf("Unauthorized : %s", err)
+		return
+	}
+
+	// Test if upload is not expired
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		redirect(req, resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	// Only files that could be downloaded one by one are put in the archive
+	var files []*common.File
+	for _, file := range upload.Files {
+		if file.Status != "uploaded" {
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		ctx.Warningf("No file available in upload %s", upload.ID)
+		redirect(req, resp, common.ErrNoFileAvailable, fmt.Sprintf("No file available in upload %s", upload.ID), 404)
+		return
+	}
+	sort.Sort(filesByName(files))
+
+	// Check yubikey
+	// If upload is yubikey protected, user must send an OTP when he wants to get an archive.
+	err = checkYubikey(ctx, req, resp, upload)
+	if err != nil {
+		return
+	}
+
+	// The archive is built on the fly so its size is unknown
+	if archiveType == "zip" {
+		resp.Header().Set("Content-Type", "application/zip")
+	} else {
+		resp.Header().Set("Content-Type", "application/x-gzip")
+	}
+	resp.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName))
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// HEAD Request => Do not print archive, user just wants http headers
+	// GET  Request => Print archive content
+	ctx.Infof("Got a %s request", req.Method)
+
+	if req.Method == "GET" {
+		var archive archiveWriter
+		if archiveType == "zip" {
+			archive = newZipArchiveWriter(resp)
+		} else {
+			archive = newTarGzArchiveWriter(resp)
+		}
+
+		for _, file := range files {
+			ctx.SetFile(file.Name)
+
+			// Get file in data backend
+			fileReader, err := dataBackend.GetDataBackend().GetFile(ctx.Fork("get file"), upload, file.ID)
+			if err != nil {
+				// Headers are already sent, the archive will be truncated
+				ctx.Warningf("Failed to get file %s in upload %s : %s", file.Name, upload.ID, err)
+				break
+			}
+			fileReader, err = compression.NewReader(file.Encoding, fileReader)
+			if err != nil {
+				ctx.Warningf("Failed to uncompress file %s in upload %s : %s", file.Name, upload.ID, err)
+				break
+			}
+
+			// Update metadata if oneShot option is set
+			if upload.OneShot {
+				file.Status = "downloaded"
+				err = metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, file)
+				if err != nil {
+					ctx.Warningf("Error while deleting file %s from upload %s metadata : %s", file.Name, upload.ID, err)
+				}
+			}
+
+			// File is piped directly to the archive without buffering
+			err = archive.AddFile(file, fileReader)
+			fileReader.Close()
+			if err != nil {
+				ctx.Warningf("Error while copying file to archive : %s", err)
+				break
+			}
+
+			// Remove file from data backend if oneShot option is set
+			if upload.OneShot {
+				err = dataBackend.GetDataBackend().RemoveFile(ctx.Fork("remove file"), upload, file.ID)
+				if err != nil {
+					ctx.Warningf("Error while deleting file %s from upload %s : %s", file.Name, upload.ID, err)
+				}
+			}
+		}
+
+		err = archive.Close()
+		if err != nil {
+			ctx.Warningf("Error while closing archive : %s", err)
+		}
+
+		// Remove upload if no files are available
+		err = RemoveUploadIfNoFileAvailable(ctx, upload)
+		if err != nil {
+			ctx.Warningf("Error while checking if upload can be removed : %s", err)
+		}
+	}
+}
+
+func getPreviewHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("get preview handler", req)
+	defer ctx.Finalize(err)
+
+	// Files must not be served from the web interface domain
+	if redirectToDownloadDomain(req, resp) {
+		return
+	}
+
+	// Get the upload id and file id from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	fileID := vars["fileID"]
+	if uploadID == "" {
+		ctx.Warning("Missing upload id")
+		redirect(req, resp, common.ErrInvalidRequest, "Missing upload id", 400)
+		return
+	}
+	if fileID == "" {
+		ctx.Warning("Missing file id")
+		redirect(req, resp, common.ErrInvalidRequest, "Missing file id", 400)
+		return
+	}
+	ctx.SetUpload(uploadID)
+
+	// Get the upload informations from the metadata backend
+	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload %s not found : %s", uploadID, err)
+		uploadNotFound(ctx, req, resp, uploadID, true)
+		return
+	}
+
+	// Handle basic auth if upload is password protected
+	err = httpBasicAuth(req, resp, upload)
+	if err != nil {
+		ctx.Warningf("Unauthorized : %s", err)
+		return
+	}
+
+	// Test if upload is not expired
+	if isExpired(upload) {
+		ctx.Warningf("Upload is expired since %s", time.Since(time.Unix(upload.Creation, int64(0)).Add(time.Duration(upload.TTL)*time.Second)).String())
+		redirect(req, resp, common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", upload.ID), 410)
+		return
+	}
+
+	file, ok := upload.Files[fileID]
+	if !ok {
+		ctx.Warningf("File %s not found", fileID)
+		redirect(req, resp, common.ErrFileNotFound, fmt.Sprintf("File %s not found", fileID), 404)
+		return
+	}
+	ctx.SetFile(file.Name)
+
+	// The preview is available as long as the file is
+	if file.Status != "uploaded" {
+		ctx.Warningf("File %s is %s", file.Name, file.Status)
+		redirect(req, resp, common.ErrFileUnavailable, fmt.Sprintf("File %s is not available", file.Name), 404)
+		return
+	}
+
+	if file.Preview == nil {
+		ctx.Warningf("No preview for file %s", file.Name)
+		redirect(req, resp, common.ErrPreviewNotFound, fmt.Sprintf("No preview available for file %s", file.Name), 404)
+		return
+	}
+
+	// Check yubikey
+	// If upload is yubikey protected, user must send an OTP when he wants to get a preview.
+	err = checkYubikey(ctx, req, resp, upload)
+	if err != nil {
+		return
+	}
+
+	resp.Header().Set("Content-Type", file.Preview.Type)
+	resp.Header().Set("Content-Length", strconv.Itoa(int(file.Preview.CurrentSize)))
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if req.Method == "GET" {
+		previewReader, err := preview.Get(ctx.Fork("get preview"), upload, file)
+		if err != nil {
+			ctx.Warningf("Failed to get preview of file %s in upload %s : %s", file.Name, upload.ID, err)
+			redirect(req, resp, common.ErrInternal, fmt.Sprintf("Failed to read preview of file %s", file.Name), 500)
+			return
+		}
+		defer previewReader.Close()
+
+		_, err = io.Copy(resp, previewReader)
+		if err != nil {
+			ctx.Warningf("Error while copying preview to response : %s", err)
+		}
+	}
+}
+
+func addFileHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("add file handler", req)
+	defer ctx.Finalize(err)
+
+	upload, err := getUploadToAddFiles(ctx, req, resp)
+	if err != nil {
+		return
+	}
+
+	// Reject files too big before reading the request body. Clients
+	// waiting for a 100 Continue response don't even send it.
+	if req.ContentLength > int64(common.Config.MaxFileSize)+multipartOverhead {
+		ctx.Warningf("Request body too big : %d bytes", req.ContentLength)
+		writeError(resp, common.ErrFileTooBig, fmt.Sprintf("File is too big (limit is set to %d bytes)", common.Config.MaxFileSize), 413)
+		return
+	}
+
+	// Get file handle from multipart request
+	multiPartReader, err := req.MultipartReader()
+	if err != nil {
+		ctx.Warningf("Failed to get file from multipart request : %s", err)
+		writeError(resp, common.ErrInvalidRequest, "Failed to get file from multipart request", 400)
+		return
+	}
+
+	files, expected, err := saveFiles(ctx, req, resp, upload, multiPartReader, nil, 1)
+	if err != nil {
+		return
+	}
+	err = addFiles(ctx, req, resp, upload, files, expected)
+	if err != nil {
+		return
+	}
+
+	// Print file metadata in the json response.
+	var json []byte
+	if json, err = utils.ToJson(files[0]); err == nil {
+		resp.Write(json)
+	} else {
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+	}
+}
+
+func addFilesHandler(resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx := common.NewPlikContext("add files handler", req)
+	defer ctx.Finalize(err)
+
+	upload, err := getUploadToAddFiles(ctx, req, resp)
+	if err != nil {
+		return
+	}
+
+	// Get files handles from multipart request
+	multiPartReader, err := req.MultipartReader()
+	if err != nil {
+		ctx.Warningf("Failed to get files from multipart request : %s", err)
+		writeError(resp, common.ErrInvalidRequest, "Failed to get files from multipart request", 400)
+		return
+	}
+
+	files, expected, err := saveFiles(ctx, req, resp, upload, multiPartReader, nil, 0)
+	if err != nil {
+		return
+	}
+	err = addFiles(ctx, req, resp, upload, files, expected)
+	if err != nil {
+		return
+	}
+
+	// Print files metadata in the json response.
+	var json []byte
+	if json, err = utils.ToJson(files); err == nil {
+		resp.Write(json)
+	} else {
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+	}
+}
+
+// getUploadToAddFiles returns the upload files are added to. The error
+// response is written if the upload does not exist or if the client
+// is not allowed to add files to it.
+func getUploadToAddFiles(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter) (upload *common.Upload, err error) {
+
+	// Get the upload id from the url params
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	ctx.SetUpload(uploadID)
+
+	// Get upload metadata
+	upload, err = metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
+	if err != nil {
+		ctx.Warningf("Upload metadata not found")
+		uploadNotFound(ctx, req, resp, uploadID, false)
+		return
+	}
+
+	// Handle basic auth if upload is password protected
+	err = httpBasicAuth(req, resp, upload)
+	if err != nil {
+		ctx.Warningf("Unauthorized : %s", err)
+		return
+	}
+
+	// Check upload token
+	if req.Header.Get("X-UploadToken") != upload.UploadToken {
+		err = ctx.EWarningf("Invalid upload token %s", req.Header.Get("X-UploadToken"))
+		writeError(resp, common.ErrUploadTokenInvalid, "Invalid upload token in X-UploadToken header", 403)
+		return
+	}
+	return
+}
+
+// saveFiles saves the data of the files of a multipart request. Each
+// "file" part can be preceded by "path", "size" and "digest" parts
+// describing it. part is the first part if it has already been read from
+// the request and max the maximum number of files ( 0 for no limit ).
+// The expected digests of the files are returned to be checked by addFiles.
+// The error response is written if a file can't be saved.
+func saveFiles(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, upload *common.Upload, multiPartReader *multipart.Reader, part *multipart.Part, max int) (files []*common.File, expected map[*common.File]map[string]string, err error) {
+
+	// Don't keep the data of the files saved before a file failed
+	defer func() {
+		if err != nil {
+			removeFilesData(ctx, upload, files)
+			files = nil
+		}
+	}()
+
+	// Read multipart body, the metadata parts apply to the next "file" part
+	var filePath, declaredSize, declaredDigest string
+	expected = make(map[*common.File]map[string]string)
+	for {
+		if part == nil {
+			part, err = multiPartReader.NextPart()
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			if err != nil {
+				ctx.Warningf("Failed to read multipart request : %s", err)
+				writeError(resp, common.ErrInvalidRequest, "Failed to read multipart request", 400)
+				return
+			}
+		}
+
+		switch part.FormName() {
+		case "path", "size", "digest":
+			value, errRead := ioutil.ReadAll(io.LimitReader(part, 4096))
+			if errRead != nil {
+				err = ctx.EWarningf("Failed to read file %s from multipart request : %s", part.FormName(), errRead)
+				writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Failed to read file %s from multipart request", part.FormName()), 400)
+				return
+			}
+			switch part.FormName() {
+			case "path":
+				filePath = string(value)
+			case "size":
+				declaredSize = string(value)
+			case "digest":
+				declaredDigest = string(value)
+			}
+		case "file":
+			if max > 0 && len(files) == max {
+				err = ctx.EWarningf("Too many files in multipart request")
+				writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Only %d file(s) can be sent in this request", max), 400)
+				return
+			}
+			if part.Header.Get("Content-Length") != "" {
+				declaredSize = part.Header.Get("Content-Length")
+			}
+
+			// Digests are computed for the configured algorithms and
+			// for the algorithms of the digests expected by the client
+			fileDigests := digest.Parse(declaredDigest)
+			algorithms := append([]string{digest.SHA256}, common.Config.ExtraDigests...)
+			for _, digests := range []map[string]string{fileDigests, expectedDigests(req)} {
+				for algorithm := range digests {
+					algorithms = append(algorithms, algorithm)
+				}
+			}
+
+			var file *common.File
+			file, err = saveFile(ctx, resp, upload, files, part, part.FileName(), filePath, declaredSize, algorithms)
+			if err != nil {
+				return
+			}
+			files = append(files, file)
+			expected[file] = fileDigests
+			filePath, declaredSize, declaredDigest = "", "", ""
+		}
+		part = nil
+	}
+	if len(files) == 0 {
+		err = ctx.EWarningf("Missing file from multipart request")
+		writeError(resp, common.ErrInvalidRequest, "Missing file from multipart request", 400)
+		return
+	}
+
+	return
+}
+
+// addFiles checks the digests of the files saved from a request and adds
+// them to the upload. Files are only added if all of them are valid. The
+// error response is written and the data of the files that have not been
+// added is removed otherwise.
+func addFiles(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, upload *common.Upload, files []*common.File, expected map[*common.File]map[string]string) (err error) {
+	defer func() {
+		if err != nil {
+			removeFilesData(ctx, upload, files)
+		}
+	}()
+
+	// Request trailers are only available once the whole body has been read
+	io.Copy(ioutil.Discard, req.Body)
+
+	// The digest of the request is the one of its only file
+	if len(files) == 1 {
+		for algorithm, sum := range expectedDigests(req) {
+			expected[files[0]][algorithm] = sum
+		}
+	}
+
+	// Reject the files that are not the ones the client expected
+	for _, file := range files {
+		if errDigest := checkDigests(file, expected[file]); errDigest != nil {
+			err = ctx.EWarningf("Invalid file %s : %s", file.Name, errDigest)
+			writeError(resp, common.ErrDigestMismatch, fmt.Sprintf("Invalid file %s : %s", file.Name, errDigest), 400)
+			return
+		}
+	}
+
+	for i, file := range files {
+		// Update upload metadata
+		upload.Files[file.ID] = file
+
+		// Generate a preview of the file. It would give access
+		// to the file content without consuming OneShot files.
+		if common.Config.PreviewEnabled && !upload.OneShot {
+			if err := preview.Generate(ctx.Fork("generate preview"), upload, file); err != nil {
+				ctx.Warningf("Unable to generate preview : %s", err)
+			}
+		}
+
+		err = metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, file)
+		if err != nil {
+			ctx.Warningf("Unable to update metadata : %s", err)
+			writeError(resp, common.ErrInternal, fmt.Sprintf("Error adding file %s to upload %s metadata : %s", file.Name, upload.ID, err), 500)
+
+			// Files already added to the metadata are kept
+			for _, f := range files[i:] {
+				delete(upload.Files, f.ID)
+			}
+			files = files[i:]
+			return
+		}
+	}
+
+	// Remove all private informations (ip, data backend details, ...) before
+	// sending metadata back to the client
+	for _, file := range files {
+		file.Sanitize()
+	}
+	return
+}
+
+// saveFile saves the data of a file of a request in the data backend. The
+// files already saved by the request are given to check their path. The
+// error response is written if the file can't be saved.
+func saveFile(ctx *common.PlikContext, resp http.ResponseWriter, upload *common.Upload, saved []*common.File, file io.Reader, fileName string, filePath string, declaredSize string, algorithms []string) (newFile *common.File, err error) {
+
+	// Reject files too big before reading them
+	if declaredSize != "" {
+		size, errSize := strconv.ParseInt(strings.TrimSpace(declaredSize), 10, 64)
+		if errSize != nil || size < 0 {
+			err = ctx.EWarningf("Invalid file size %s", declaredSize)
+			writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Invalid file size %s", declaredSize), 400)
+			return
+		}
+		if size > int64(common.Config.MaxFileSize) {
+			err = ctx.EWarningf("File too big : %d bytes", size)
+			writeError(resp, common.ErrFileTooBig, fmt.Sprintf("File is too big (limit is set to %d bytes)", common.Config.MaxFileSize), 413)
+			return
+		}
+	}
+
+	if fileName == "" {
+		err = ctx.EWarningf("Missing file name")
+		writeError(resp, common.ErrInvalidRequest, "Missing file name", 400)
+		return
+	}
+
+	// The file name is the last element of its path
+	if filePath != "" {
+		filePath, err = common.CleanPath(filePath)
+		if err != nil {
+			ctx.Warningf("Invalid file path : %s", err)
+			writeError(resp, common.ErrInvalidRequest, fmt.Sprintf("Invalid file path : %s", err), 400)
+			return
+		}
+		fileName = path.Base(filePath)
+
+		for _, f := range upload.Files {
+			if f.FullPath() == filePath && (f.Status == "uploading" || f.Status == "uploaded") {
+				err = ctx.EWarningf("File %s already exists", filePath)
+				writeError(resp, common.ErrFileExists, fmt.Sprintf("File %s already exists", filePath), 400)
+				return
+			}
+		}
+		for _, f := range saved {
+			if f.FullPath() == filePath {
+				err = ctx.EWarningf("File %s already exists", filePath)
+				writeError(resp, common.ErrFileExists, fmt.Sprintf("File %s already exists", filePath), 400)
+				return
+			}
 		}
-	}
-	if file == nil {
-		ctx.Warning("Missing file from multipart request")
-		http.Error(resp, common.NewResult("Missing file from multipart request", nil).ToJSONString(), 400)
-	}
-	if fileName == "" {
-		ctx.Warning("Missing file name from multipart request")
-		http.Error(resp, common.NewResult("Missing file name from multipart request", nil).ToJSONString(), 400)
 	}
 
 	// Create a new file object
-	newFile := common.NewFile()
+	newFile = common.NewFile()
 	newFile.Name = fileName
+	newFile.Path = filePath
 	newFile.Type = "application/octet-stream"
 	ctx.SetFile(fileName)
 
+	hashes := make(map[string]hash.Hash)
+	for _, algorithm := range algorithms {
+		if h := digest.New(algorithm); h != nil {
+			hashes[algorithm] = h
+		}
+	}
+
 	// Pipe file data from the request body to a preprocessing goroutine
 	//  - Guess content type
-	//  - Compute md5sum
+	//  - Compute md5sum and digests ( sha-256 identifies file content to deduplicate data )
 	//  - Limit upload size
+	//  - Compress data at rest
+	// The goroutine must be done before the next part of the request is read
 	preprocessReader, preprocessWriter := io.Pipe()
 	md5Hash := md5.New()
 	totalBytes := 0
+	tooBig := false
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+		var dataWriter io.Writer = preprocessWriter
+		var compressor io.WriteCloser
 		for {
 			buf := make([]byte, 1024)
-			bytesRead, err := file.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					ctx.Warningf("Unable to read data from request body : %s", err)
+			bytesRead, err := io.ReadFull(file, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			buf = buf[:bytesRead]
+
+			if bytesRead > 0 {
+				// Detect the content-type using the 512 first bytes
+				if totalBytes == 0 {
+					newFile.Type = http.DetectContentType(buf)
+					ctx.Infof("Got Content-Type : %s", newFile.Type)
+
+					// The data backend stores compressible files compressed
+					if common.Config.CompressionAtRest != "" && common.MatchContentType(newFile.Type, common.Config.CompressibleContentTypes) {
+						var errCompress error
+						compressor, errCompress = compression.NewWriter(common.Config.CompressionAtRest, preprocessWriter)
+						if errCompress != nil {
+							errCompress = ctx.EWarningf("Unable to compress file : %s", errCompress)
+							preprocessWriter.CloseWithError(errCompress)
+							return
+						}
+						newFile.Encoding = common.Config.CompressionAtRest
+						dataWriter = compressor
+					}
 				}
 
-				preprocessWriter.Close()
-				return
-			}
+				// Increment size
+				totalBytes += bytesRead
+
+				// Compute md5sum and digests
+				md5Hash.Write(buf)
+				for _, h := range hashes {
+					h.Write(buf)
+				}
+
+				// Check upload max size limit
+				if totalBytes > common.Config.MaxFileSize {
+					tooBig = true
+					err = ctx.EWarningf("File too big (limit is set to %d bytes)", common.Config.MaxFileSize)
+					preprocessWriter.CloseWithError(err)
+					return
+				}
 
-			// Detect the content-type using the 512 first bytes
-			if totalBytes == 0 {
-				newFile.Type = http.DetectContentType(buf)
-				ctx.Infof("Got Content-Type : %s", newFile.Type)
+				// Pass file data to data backend
+				if _, err := dataWriter.Write(buf); err != nil {
+					preprocessWriter.CloseWithError(err)
+					return
+				}
 			}
 
-			// Increment size
-			totalBytes += bytesRead
+			if err != nil {
+				if err != io.EOF {
+					err = ctx.EWarningf("Unable to read data from request body : %s", err)
+					preprocessWriter.CloseWithError(err)
+					return
+				}
+
+				// Flush compressed data
+				if compressor != nil {
+					if err := compressor.Close(); err != nil {
+						preprocessWriter.CloseWithError(err)
+						return
+					}
+				}
 
-			// Compute md5sum
-			md5Hash.Write(buf[:bytesRead])
+				// The data backend may need the digests once it has read all the data
+				for algorithm, h := range hashes {
+					newFile.SetDigest(algorithm, fmt.Sprintf("%x", h.Sum(nil)))
+				}
 
-			// Check upload max size limit
-			if totalBytes > common.Config.MaxFileSize {
-				err = ctx.EWarningf("File too big (limit is set to %d bytes)", common.Config.MaxFileSize)
-				preprocessWriter.CloseWithError(err)
+				preprocessWriter.Close()
 				return
 			}
-
-			// Pass file data to data backend
-			preprocessWriter.Write(buf[:bytesRead])
 		}
 	}()
 
 	// Save file in the data backend
 	backendDetails, err := dataBackend.GetDataBackend().AddFile(ctx.Fork("save file"), upload, newFile, preprocessReader)
+	preprocessReader.Close()
+	<-done
 	if err != nil {
 		ctx.Warningf("Unable to save file : %s", err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Error saving file %s in upload %s : %s", newFile.Name, upload.ID, err), nil).ToJSONString(), 500)
+		if tooBig {
+			writeError(resp, common.ErrFileTooBig, fmt.Sprintf("File %s is too big (limit is set to %d bytes)", newFile.Name, common.Config.MaxFileSize), 413)
+			return
+		}
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Error saving file %s in upload %s : %s", newFile.Name, upload.ID, err), 500)
 		return
 	}
 
@@ -623,26 +1715,17 @@ func addFileHandler(resp http.ResponseWriter, req *http.Request) {
 	newFile.Md5 = fmt.Sprintf("%x", md5Hash.Sum(nil))
 	newFile.UploadDate = time.Now().Unix()
 	newFile.BackendDetails = backendDetails
+	return
+}
 
-	// Update upload metadata
-	upload.Files[newFile.ID] = newFile
-	err = metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, newFile)
-	if err != nil {
-		ctx.Warningf("Unable to update metadata : %s", err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Error adding file %s to upload %s metadata : %s", newFile.Name, upload.ID, err), nil).ToJSONString(), 500)
-		return
-	}
-
-	// Remove all private informations (ip, data backend details, ...) before
-	// sending metadata back to the client
-	newFile.Sanitize()
-
-	// Print file metadata in the json response.
-	var json []byte
-	if json, err = utils.ToJson(newFile); err == nil {
-		resp.Write(json)
-	} else {
-		http.Error(resp, common.NewResult("Unable to serialize response body", nil).ToJSONString(), 500)
+// removeFilesData removes the data of files that are not in the upload metadata
+func removeFilesData(ctx *common.PlikContext, upload *common.Upload, files []*common.File) {
+	for _, file := range files {
+		u := *upload
+		u.Files = map[string]*common.File{file.ID: file}
+		if err := dataBackend.GetDataBackend().RemoveFile(ctx.Fork("remove file"), &u, file.ID); err != nil {
+			ctx.Warningf("Unable to remove file %s : %s", file.Name, err)
+		}
 	}
 }
 
@@ -657,12 +1740,12 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	fileID := vars["fileID"]
 	if uploadID == "" {
 		ctx.Warning("Missing upload id")
-		redirect(req, resp, errors.New("Missing upload id"), 404)
+		redirect(req, resp, common.ErrInvalidRequest, "Missing upload id", 400)
 		return
 	}
 	if fileID == "" {
 		ctx.Warning("Missing file id")
-		redirect(req, resp, errors.New("Missing file id"), 404)
+		redirect(req, resp, common.ErrInvalidRequest, "Missing file id", 400)
 		return
 	}
 	ctx.SetUpload(uploadID)
@@ -671,7 +1754,7 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	upload, err := metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), uploadID)
 	if err != nil {
 		ctx.Warning("Upload not found")
-		http.Error(resp, common.NewResult(fmt.Sprintf("Upload not %s found", uploadID), nil).ToJSONString(), 404)
+		uploadNotFound(ctx, req, resp, uploadID, false)
 		return
 	}
 
@@ -685,7 +1768,7 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	// Test if upload is removable
 	if !upload.Removable {
 		ctx.Warningf("User tried to remove file %s of an non removeable upload", fileID)
-		redirect(req, resp, errors.New("Can't remove files on this upload"), 401)
+		redirect(req, resp, common.ErrNotRemovable, "Can't remove files on this upload", 403)
 		return
 	}
 
@@ -693,7 +1776,7 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	file, ok := upload.Files[fileID]
 	if !ok {
 		ctx.Warningf("File not found")
-		http.Error(resp, common.NewResult(fmt.Sprintf("File %s not found in upload %s", fileID, upload.ID), nil).ToJSONString(), 404)
+		writeError(resp, common.ErrFileNotFound, fmt.Sprintf("File %s not found in upload %s", fileID, upload.ID), 404)
 		return
 	}
 
@@ -701,17 +1784,22 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	file.Status = "removed"
 	if err := metadataBackend.GetMetaDataBackend().AddOrUpdateFile(ctx.Fork("update metadata"), upload, file); err != nil {
 		ctx.Warningf("Error while updating file metadata : %s", err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Error while updating file %s metadata in upload %s", file.Name, upload.ID), nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Error while updating file %s metadata in upload %s", file.Name, upload.ID), 500)
 		return
 	}
 
 	// Remove file from data backend
 	if err := dataBackend.GetDataBackend().RemoveFile(ctx.Fork("remove file"), upload, file.ID); err != nil {
 		ctx.Warningf("Error while deleting file : %s", err)
-		http.Error(resp, common.NewResult(fmt.Sprintf("Error while deleting file %s in upload %s", file.Name, upload.ID), nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, fmt.Sprintf("Error while deleting file %s in upload %s", file.Name, upload.ID), 500)
 		return
 	}
 
+	// Remove file preview from data backend
+	if err := preview.Remove(ctx.Fork("remove preview"), upload, file); err != nil {
+		ctx.Warningf("Error while deleting file preview : %s", err)
+	}
+
 	// Remove upload if no files anymore
 	err = RemoveUploadIfNoFileAvailable(ctx, upload)
 	if err != nil {
@@ -722,8 +1810,32 @@ func removeFileHandler(resp http.ResponseWriter, req *http.Request) {
 	var json []byte
 	if json, err = utils.ToJson(upload); err != nil {
 		ctx.Warningf("Unable to serialize response body : %s", err)
-		http.Error(resp, common.NewResult("Unable to serialize response body", nil).ToJSONString(), 500)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+	}
+	resp.Write(json)
+}
+
+func getConfigHandler(resp http.ResponseWriter, req *http.Request) {
+	// Only the limits and the features of the server are sent,
+	// never the backends configuration or the secrets
+	json, err := utils.ToJson(common.Config.Public())
+	if err != nil {
+		log.Warningf("Unable to serialize response body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+		return
 	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(json)
+}
+
+func getVersionHandler(resp http.ResponseWriter, req *http.Request) {
+	json, err := utils.ToJson(&common.VersionResult{Version: common.GetVersion()})
+	if err != nil {
+		log.Warningf("Unable to serialize response body : %s", err)
+		writeError(resp, common.ErrInternal, "Unable to serialize response body", 500)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
 	resp.Write(json)
 }
 
@@ -758,29 +1870,326 @@ func httpBasicAuth(req *http.Request, resp http.ResponseWriter, upload *common.U
 			// WWW-Authenticate header tells the client to retry the request
 			// with valid http basic credentials set in the Authorization headers.
 			resp.Header().Set("WWW-Authenticate", "Basic realm=\"plik\"")
-			http.Error(resp, "Please provide valid credentials to download this file", 401)
+			writeError(resp, common.ErrAuthRequired, "Please provide valid credentials to download this file", 401)
 		}
 	}
 	return
 }
 
-var userAgents = []string{"wget", "curl", "python-urllib", "libwwww-perl", "php", "pycurl"}
+// checkYubikey validates the OTP found in the url when the upload is yubikey
+// protected. Like httpBasicAuth, the error response is sent to the client.
+func checkYubikey(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, upload *common.Upload) (err error) {
+	if upload.Yubikey == "" {
+		return
+	}
 
-func redirect(req *http.Request, resp http.ResponseWriter, err error, status int) {
-	// The web client uses http redirect to get errors
-	// from http redirect and display a nice HTML error message
-	// But cli clients needs a clean string response
-	userAgent := strings.ToLower(req.UserAgent())
-	for _, ua := range userAgents {
-		if strings.HasPrefix(userAgent, ua) {
-			http.Error(resp, err.Error(), status)
-			return
+	// The OTP is in the url of the legacy routes
+	token := req.Header.Get("X-Yubikey")
+	if token == "" {
+		token = mux.Vars(req)["yubikey"]
+	}
+	if token == "" {
+		ctx.Warningf("Missing yubikey token")
+		err = errors.New("Invalid yubikey token")
+		redirect(req, resp, common.ErrYubikeyInvalid, err.Error(), 401)
+		return
+	}
+	if len(token) != 44 {
+		ctx.Warningf("Invalid yubikey token : %s", token)
+		err = errors.New("Invalid yubikey token")
+		redirect(req, resp, common.ErrYubikeyInvalid, err.Error(), 401)
+		return
+	}
+	if token[:12] != upload.Yubikey {
+		ctx.Warningf("Invalid yubikey device : %s", token)
+		err = errors.New("Invalid yubikey token")
+		redirect(req, resp, common.ErrYubikeyInvalid, err.Error(), 401)
+		return
+	}
+
+	// Error if yubikey is disabled on server, and enabled on upload
+	if !common.Config.YubikeyEnabled {
+		ctx.Warningf("Got a Yubikey upload but Yubikey backend is disabled")
+		err = errors.New("Yubikey are disabled on this server")
+		redirect(req, resp, common.ErrYubikeyDisabled, err.Error(), 500)
+		return
+	}
+
+	_, isValid, err := common.Config.YubiAuth.Verify(token)
+	if err != nil {
+		ctx.Warningf("Failed to validate yubikey token : %s", err)
+		err = errors.New("Invalid yubikey token")
+		redirect(req, resp, common.ErrYubikeyInvalid, err.Error(), 401)
+		return
+	}
+	if !isValid {
+		ctx.Warningf("Invalid yubikey token : %s", token)
+		err = errors.New("Invalid yubikey token")
+		redirect(req, resp, common.ErrYubikeyInvalid, err.Error(), 401)
+		return
+	}
+
+	return
+}
+
+// isExpired tells if the upload TTL is over
+func isExpired(upload *common.Upload) bool {
+	if upload.TTL > 0 {
+		return time.Now().Unix() >= (upload.Creation + int64(upload.TTL))
+	}
+	return false
+}
+
+// expectedDigests returns the digests of the uploaded file sent by the client
+// in Repr-Digest or Digest request headers or trailers by algorithm
+func expectedDigests(req *http.Request) (digests map[string]string) {
+	digests = make(map[string]string)
+	for _, header := range []http.Header{req.Header, req.Trailer} {
+		for _, name := range []string{"Digest", "Repr-Digest"} {
+			for algorithm, sum := range digest.Parse(header.Get(name)) {
+				digests[algorithm] = sum
+			}
+		}
+	}
+	return
+}
+
+// checkDigests compares the digests of the file with the expected ones.
+// At least one of the expected digests must have been computed.
+func checkDigests(file *common.File, expected map[string]string) (err error) {
+	if len(expected) == 0 {
+		return
+	}
+
+	checked := false
+	digests := file.Digests()
+	for algorithm, sum := range expected {
+		if computed, ok := digests[algorithm]; ok {
+			if computed != sum {
+				return fmt.Errorf("%s digest mismatch (got %s, expected %s)", algorithm, computed, sum)
+			}
+			checked = true
+		}
+	}
+	if !checked {
+		return errors.New("Unsupported digest algorithm")
+	}
+
+	return
+}
+
+// parseRange reads a single range Range header ( bytes=start-end, bytes=start-,
+// bytes=-suffix ) and returns the first and last byte positions. Requests for
+// multiple ranges get the whole file, end is then set to -1.
+func parseRange(header string, size int64) (start int64, end int64, err error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, -1, errors.New("Unsupported range unit")
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, -1, nil
+	}
+
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, -1, errors.New("Invalid range")
+	}
+
+	if i == 0 {
+		// Last bytes of the file
+		var suffix int64
+		suffix, err = strconv.ParseInt(spec[1:], 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, -1, errors.New("Invalid range")
+		}
+		if suffix > size {
+			suffix = size
+		}
+		start, end = size-suffix, size-1
+	} else {
+		start, err = strconv.ParseInt(spec[:i], 10, 64)
+		if err != nil || start < 0 {
+			return 0, -1, errors.New("Invalid range")
+		}
+		end = size - 1
+		if spec[i+1:] != "" {
+			end, err = strconv.ParseInt(spec[i+1:], 10, 64)
+			if err != nil || end < start {
+				return 0, -1, errors.New("Invalid range")
+			}
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+	}
+
+	if start >= size {
+		return 0, -1, errors.New("Range not satisfiable")
+	}
+
+	return
+}
+
+// compressTo compresses data from reader to writer with the given encoding
+func compressTo(writer io.Writer, encoding string, reader io.Reader) (err error) {
+	compressor, err := compression.NewWriter(encoding, writer)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(compressor, reader)
+	if err != nil {
+		return
+	}
+	return compressor.Close()
+}
+
+// redirectToDownloadDomain sends the client to the same url on the download
+// domain if one is configured. Files served from the domain of the web
+// interface could run scripts with access to the web interface.
+func redirectToDownloadDomain(req *http.Request, resp http.ResponseWriter) bool {
+	downloadDomain := common.Config.DownloadDomainURL
+	if downloadDomain == nil || req.Host == downloadDomain.Host {
+		return false
+	}
+	http.Redirect(resp, req, downloadDomain.Scheme+"://"+downloadDomain.Host+req.RequestURI, 301)
+	return true
+}
+
+// isInlineContentType tells if a content type is in the list of
+// types browsers are allowed to display
+func isInlineContentType(contentType string) bool {
+	return common.MatchContentType(contentType, common.Config.InlineContentTypes)
+}
+
+// contentDisposition builds a Content-Disposition header value (RFC 6266).
+// The quoted filename is an ascii fallback for old clients, others use
+// the RFC 5987 encoded version that handles any character.
+func contentDisposition(disposition string, filename string) string {
+	fallback := []rune(filename)
+	for i, r := range fallback {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' {
+			fallback[i] = '_'
+		}
+	}
+
+	value := disposition + "; filename=\"" + string(fallback) + "\""
+	if string(fallback) != filename {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent encodes every byte that is not an attr-char
+func encodeRFC5987(value string) string {
+	encoded := ""
+	for _, b := range []byte(value) {
+		if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded += string(b)
+		} else {
+			encoded += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return encoded
+}
+
+// writeError sends an error response with the stable code of the error
+func writeError(resp http.ResponseWriter, code string, message string, status int) {
+	// Headers of the file may have been set already
+	resp.Header().Del("Content-Length")
+	resp.Header().Del("Content-Encoding")
+	resp.Header().Del("Content-Disposition")
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+	resp.Write(common.NewErrorResult(code, message).ToJSON())
+}
+
+// uploadNotFound sends the error of a request for an upload missing from
+// the metadata backend. Expired uploads leave a tombstone when they are
+// removed so the error tells an expired link from an invalid one.
+func uploadNotFound(ctx *common.PlikContext, req *http.Request, resp http.ResponseWriter, uploadID string, download bool) {
+	code, message, status := common.ErrUploadNotFound, fmt.Sprintf("Upload %s not found", uploadID), 404
+	if common.Config.TombstoneTTL > 0 {
+		tombstone, err := metadataBackend.GetMetaDataBackend().GetTombstone(ctx.Fork("get tombstone"), uploadID)
+		if err == nil {
+			ctx.Warningf("Upload %s has expired at %s", uploadID, time.Unix(tombstone.Expiration, 0))
+			code, message, status = common.ErrUploadExpired, fmt.Sprintf("Upload %s is expired", uploadID), 410
+		}
+	}
+
+	if download {
+		redirect(req, resp, code, message, status)
+	} else {
+		writeError(resp, code, message, status)
+	}
+}
+
+// redirect sends the error of a download link. Browsers navigating to
+// the link are redirected to the web client which displays a nice HTML
+// error message. Other clients get the error and its status code.
+func redirect(req *http.Request, resp http.ResponseWriter, code string, message string, status int) {
+	if req.Method != "GET" || !prefersHTML(req) {
+		writeError(resp, code, message, status)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("err", message)
+	query.Set("errcode", strconv.Itoa(status))
+	query.Set("code", code)
+	query.Set("uri", req.RequestURI)
+
+	// Headers of the file may have been set already, http.Redirect
+	// only writes its html body without a Content-Type
+	resp.Header().Del("Content-Length")
+	resp.Header().Del("Content-Encoding")
+	resp.Header().Del("Content-Disposition")
+	resp.Header().Del("Content-Type")
+
+	// The web client does not decode + as spaces
+	http.Redirect(resp, req, "/#/?"+strings.Replace(query.Encode(), "+", "%20", -1), 301)
+}
+
+// prefersHTML tells if the Accept header of the request ranks
+// text/html above application/json, like browsers navigating do
+func prefersHTML(req *http.Request) bool {
+	return acceptQuality(req, "text/html") > acceptQuality(req, "application/json")
+}
+
+// acceptQuality returns the quality of the media type
+// in the Accept header of the request, 0 if it is missing
+func acceptQuality(req *http.Request, mediaType string) (quality float64) {
+	for _, mediaRange := range strings.Split(req.Header.Get("Accept"), ",") {
+		params := strings.Split(mediaRange, ";")
+		if strings.ToLower(strings.TrimSpace(params[0])) != mediaType {
+			continue
+		}
+		quality = 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
 		}
 	}
-	http.Redirect(resp, req, fmt.Sprintf("/#/?err=%s&errcode=%d&uri=%s", err.Error(), status, req.RequestURI), 301)
 	return
 }
 
+// fileURL returns the url to download the file
+func fileURL(req *http.Request, upload *common.Upload, file *common.File) string {
+	base := strings.TrimSuffix(common.Config.DownloadDomain, "/")
+	if base == "" {
+		scheme := "http"
+		if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + req.Host
+	}
+	return fmt.Sprintf("%s/file/%s/%s/%s", base, upload.ID, file.ID, url.PathEscape(file.Name))
+}
+
 // UploadsCleaningRoutine periodicaly remove expired uploads
 func UploadsCleaningRoutine() {
 	ctx := common.RootContext().Fork("clean expired uploads")
@@ -796,41 +2205,68 @@ func UploadsCleaningRoutine() {
 		// Get uploads that needs to be removed
 		log.Infof("Cleaning expired uploads...")
 
+		// Forget the uploads expired for too long
+		if common.Config.TombstoneTTL > 0 {
+			childCtx := ctx.Fork("remove tombstones")
+			childCtx.AutoDetach()
+			err := metadataBackend.GetMetaDataBackend().RemoveTombstones(childCtx, time.Now().Unix()-int64(common.Config.TombstoneTTL))
+			if err != nil {
+				log.Warningf("Unable to remove tombstones : %s", err)
+			}
+		}
+
 		uploadIds, err := metadataBackend.GetMetaDataBackend().GetUploadsToRemove(ctx)
 		if err != nil {
-			log.Warningf("Failed to get expired uploads : %s")
+			log.Warningf("Failed to get expired uploads : %s", err)
 		} else {
 
 			// Remove them
 			for _, uploadID := range uploadIds {
-				ctx.SetUpload(uploadID)
-				log.Infof("Removing expired upload %s", uploadID)
-				// Get upload metadata
-				childCtx := ctx.Fork("get metadata")
-				childCtx.AutoDetach()
-				upload, err := metadataBackend.GetMetaDataBackend().Get(childCtx, uploadID)
-				if err != nil {
-					log.Warningf("Unable to get infos for upload: %s", err)
-					continue
-				}
+				removeExpiredUpload(ctx, uploadID)
+			}
+		}
+	}
+}
 
-				// Remove from data backend
-				childCtx = ctx.Fork("remove upload data")
-				childCtx.AutoDetach()
-				err = dataBackend.GetDataBackend().RemoveUpload(childCtx, upload)
-				if err != nil {
-					log.Warningf("Unable to remove upload data : %s", err)
-					continue
-				}
+// removeExpiredUpload removes the data and the metadata of an
+// expired upload and leaves a tombstone in its place
+func removeExpiredUpload(ctx *common.PlikContext, uploadID string) {
+	ctx.SetUpload(uploadID)
+	log.Infof("Removing expired upload %s", uploadID)
+	// Get upload metadata
+	childCtx := ctx.Fork("get metadata")
+	childCtx.AutoDetach()
+	upload, err := metadataBackend.GetMetaDataBackend().Get(childCtx, uploadID)
+	if err != nil {
+		log.Warningf("Unable to get infos for upload: %s", err)
+		return
+	}
 
-				// Remove from metadata backend
-				childCtx = ctx.Fork("remove upload metadata")
-				childCtx.AutoDetach()
-				err = metadataBackend.GetMetaDataBackend().Remove(childCtx, upload)
-				if err != nil {
-					log.Warningf("Unable to remove upload metadata : %s", err)
-				}
-			}
+	// Remove from data backend
+	childCtx = ctx.Fork("remove upload data")
+	childCtx.AutoDetach()
+	err = dataBackend.GetDataBackend().RemoveUpload(childCtx, upload)
+	if err != nil {
+		log.Warningf("Unable to remove upload data : %s", err)
+		return
+	}
+
+	// Remove from metadata backend
+	childCtx = ctx.Fork("remove upload metadata")
+	childCtx.AutoDetach()
+	err = metadataBackend.GetMetaDataBackend().Remove(childCtx, upload)
+	if err != nil {
+		log.Warningf("Unable to remove upload metadata : %s", err)
+		return
+	}
+
+	// Leave a tombstone to answer that the upload has expired
+	if common.Config.TombstoneTTL > 0 {
+		childCtx = ctx.Fork("create tombstone")
+		childCtx.AutoDetach()
+		err = metadataBackend.GetMetaDataBackend().CreateTombstone(childCtx, common.NewTombstone(upload))
+		if err != nil {
+			log.Warningf("Unable to create tombstone : %s", err)
 		}
 	}
 }
@@ -30,18 +30,32 @@ THE SOFTWARE.
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/dataBackend"
+	"github.com/root-gg/plik/server/metadataBackend"
+	"github.com/root-gg/plik/server/openapi"
 )
 
 var (
@@ -53,6 +67,22 @@ var (
 	err             error
 )
 
+// localServer runs the handlers in the test process with backends in a
+// temporary directory, for the tests needing their own configuration
+var (
+	localServer    *httptest.Server
+	localDirectory string
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if localServer != nil {
+		localServer.Close()
+		os.RemoveAll(localDirectory)
+	}
+	os.Exit(code)
+}
+
 func TestSimpleFileUploadAndGet(t *testing.T) {
 	upload := createUpload(&common.Upload{}, t)
 	file := uploadFile(upload, "test", readerForUpload, t)
@@ -78,6 +108,40 @@ func TestMultipleFilesUploadAndGet(t *testing.T) {
 	test("getFile", upload, file4, 200, t)
 }
 
+func TestConcurrentFileUploads(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+
+	// The metadata is rewritten for each new file. Requests reading
+	// it meanwhile must never get a partially written metadata file.
+	results := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		go func(i int) {
+			code, _, err := uploadFileWithDigest(upload, fmt.Sprintf("file%d", i), strings.NewReader(contentToUpload), "")
+			if err == nil && code != 200 {
+				err = fmt.Errorf("Got http code %d uploading file%d", code, i)
+			}
+			results <- err
+		}(i)
+		go func() {
+			code, _, err := getUpload(upload.ID)
+			if err == nil && code != 200 {
+				err = fmt.Errorf("Got http code %d getting the upload", code)
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("Concurrent request on upload %s failed : %s", upload.ID, err)
+		}
+	}
+
+	_, uploadInfo, err := getUpload(upload.ID)
+	if err != nil || len(uploadInfo.Files) != 50 {
+		t.Fatalf("Upload %s has %d files after 50 concurrent uploads : %s", upload.ID, len(uploadInfo.Files), err)
+	}
+}
+
 func TestNonExistingUpload(t *testing.T) {
 	fake := common.NewUpload()
 	fake.ID = "f4s6f4sd4f56sd4f64sd6f4s64f6sd4f4s56df4s"
@@ -117,7 +181,7 @@ func TestRemovable(t *testing.T) {
 	fileRemovable := uploadFile(uploadRemovable, "test", readerForUpload, t)
 
 	// Should fail on classic upload
-	test("removeFile", upload, file, 401, t)
+	test("removeFile", upload, file, 403, t)
 
 	// Should work on removable upload
 	test("removeFile", uploadRemovable, fileRemovable, 200, t)
@@ -151,197 +215,1852 @@ func TestTtl(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// Should fail as the ttl is 1second, and we slept 1,5sec
-	test("getFile", upload, file, 404, t)
+	test("getFile", upload, file, 410, t)
 }
 
-//
-//// Subs for creating uploads and uploading files
-//
+func TestUpdateTTL(t *testing.T) {
+	upload := createUpload(&common.Upload{TTL: 1}, t)
+	file := uploadFile(upload, "test", readerForUpload, t)
 
-func createUpload(uploadParams *common.Upload, t *testing.T) (upload *common.Upload) {
-	var URL *url.URL
-	URL, err = url.Parse(plikURL + "/upload")
+	code, _, err := updateTTL(&common.Upload{ID: upload.ID}, 60)
 	if err != nil {
-		t.Fatalf("Error parsing url : %s", err)
+		t.Fatalf("Failed to update ttl : %s", err)
 	}
-
-	var j []byte
-	j, err = json.Marshal(uploadParams)
-	if err != nil {
-		t.Fatalf("Error marshalling json : %s", err)
+	if code != 403 {
+		t.Fatalf("We got http code %d updating ttl without upload token. We expected 403", code)
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest("POST", URL.String(), bytes.NewBuffer(j))
-	if err != nil {
-		t.Fatalf("Error creating request : %s", err)
+	code, updated, err := updateTTL(upload, 60)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to update ttl : %d %s", code, err)
+	}
+	if updated.TTL < 60 || updated.TTL > 62 {
+		t.Fatalf("Got ttl %d. We expected 60", updated.TTL)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-ClientApp", "go_test")
-	req.Header.Set("Referer", plikURL)
+	// Should still work after the initial ttl
+	time.Sleep(time.Second)
+	test("getFile", upload, file, 200, t)
+}
 
-	var resp *http.Response
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatalf("Error creating upload : %s", err)
-	}
+func TestRemoveUpload(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test", readerForUpload, t)
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	code, err := removeUpload(&common.Upload{ID: upload.ID})
 	if err != nil {
-		t.Fatalf("Error reading response body : %s", err)
+		t.Fatalf("Failed to remove upload : %s", err)
+	}
+	if code != 403 {
+		t.Fatalf("We got http code %d removing upload without upload token. We expected 403", code)
 	}
+	test("getFile", upload, file, 200, t)
 
-	basicAuth = resp.Header.Get("Authorization")
+	code, err = removeUpload(upload)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to remove upload : %d %s", code, err)
+	}
+	test("getFile", upload, file, 404, t)
 
-	// Parse Json
-	upload = new(common.Upload)
-	err = json.Unmarshal(body, upload)
+	code, _, err = getUpload(upload.ID)
 	if err != nil {
-		t.Fatalf("Error unmarshalling json into upload : %s", err)
+		t.Fatalf("Failed to get upload : %s", err)
+	}
+	if code != 404 {
+		t.Fatalf("We got http code %d getting a removed upload. We expected 404", code)
 	}
-
-	return
 }
 
-func uploadFile(uploadInfo *common.Upload, name string, reader *strings.Reader, t *testing.T) (file *common.File) {
-	pipeReader, pipeWriter := io.Pipe()
-	multipartWriter := multipart.NewWriter(pipeWriter)
+func TestArchive(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	uploadFile(upload, "test1", readerForUpload, t)
+	uploadFile(upload, "test2", readerForUpload, t)
 
-	go func() error {
-		part, err := multipartWriter.CreateFormFile("file", name)
+	for _, name := range []string{"archive.zip", "archive.tar.gz"} {
+		code, content, err := getArchive(upload, name)
 		if err != nil {
-			t.Fatalf("Error creating multipart form : %s", err)
+			t.Fatalf("Failed to get archive %s from upload %s : %s", name, upload.ID, err)
+		}
+		if code != 200 {
+			t.Fatalf("We got http code %d on archive %s on upload %s. We expected 200", code, name, upload.ID)
 		}
 
-		_, err = io.Copy(part, reader)
+		files, err := readArchive(name, content)
 		if err != nil {
-			t.Fatalf("Error copying file data to multipart part : %s", err)
+			t.Fatalf("Unable to read archive %s : %s", name, err)
+		}
+		if len(files) != 2 || files["test1"] != contentToUpload || files["test2"] != contentToUpload {
+			t.Fatalf("Invalid archive %s content : %v", name, files)
 		}
+	}
 
-		err = multipartWriter.Close()
-		return pipeWriter.CloseWithError(err)
-	}()
+	// Bad archive extension
+	code, _, err := getArchive(upload, "archive.rar")
+	if err != nil {
+		t.Fatalf("Failed to get archive from upload %s : %s", upload.ID, err)
+	}
+	if code != 400 {
+		t.Fatalf("We got http code %d on a bad archive name. We expected 400", code)
+	}
+}
 
-	var URL *url.URL
-	URL, err = url.Parse(plikURL + "/upload/" + uploadInfo.ID + "/file")
+func TestOneShotArchive(t *testing.T) {
+	upload := createUpload(&common.Upload{OneShot: true}, t)
+	file := uploadFile(upload, "test", readerForUpload, t)
+
+	code, _, err := getArchive(upload, "archive.zip")
 	if err != nil {
-		t.Fatalf("Error parsing url : %s", err)
+		t.Fatalf("Failed to get archive from upload %s : %s", upload.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on archive on upload %s. We expected 200", code, upload.ID)
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest("POST", URL.String(), pipeReader)
+	// Files in the archive have been downloaded
+	test("getFile", upload, file, 404, t)
+}
+
+func TestPreview(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test.txt", readerForUpload, t)
+	if file.Preview == nil {
+		t.Skip("Previews are disabled on this server")
+	}
+
+	code, content, err := getPreview(upload, file)
 	if err != nil {
-		t.Fatalf("Error creating file upload request : %s", err)
+		t.Fatalf("Failed to get preview of file %s from upload %s : %s", file.ID, upload.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on preview of file %s on upload %s. We expected 200", code, file.ID, upload.ID)
+	}
+	if content != contentToUpload {
+		t.Fatalf("Did not get expected preview (%s) of file %s on upload %s. We expected %s", content, file.ID, upload.ID, contentToUpload)
 	}
 
-	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
-	req.Header.Set("X-ClientApp", "cli_client")
-	req.Header.Set("X-UploadToken", uploadInfo.UploadToken)
+	// The preview route of the api must not be taken for a file named preview
+	code, _, body, err := apiRequest("GET", "/uploads/"+upload.ID+"/previews/"+file.ID, "", nil, "")
+	if err != nil || code != 200 || string(body) != contentToUpload {
+		t.Fatalf("We got http code %d and content %q on the api preview of file %s. We expected 200 %s : %s", code, body, file.ID, contentToUpload, err)
+	}
+	preview := uploadFile(upload, "preview", readerForUpload, t)
+	test("getFile", upload, preview, 200, t)
+	code, _, body, err = apiRequest("GET", "/uploads/"+upload.ID+"/files/"+preview.ID+"/preview", "", nil, "")
+	if err != nil || code != 200 || string(body) != contentToUpload {
+		t.Fatalf("We got http code %d and content %q downloading a file named preview from the api. We expected 200 %s : %s", code, body, contentToUpload, err)
+	}
 
-	if uploadInfo.ProtectedByPassword {
-		req.Header.Set("Authorization", basicAuth)
+	// No preview for OneShot uploads
+	uploadOneShot := createUpload(&common.Upload{OneShot: true}, t)
+	fileOneShot := uploadFile(uploadOneShot, "test.txt", readerForUpload, t)
+	if fileOneShot.Preview != nil {
+		t.Fatalf("Got a preview for a OneShot upload")
 	}
+}
 
-	var resp *http.Response
-	resp, err = client.Do(req)
+func TestContentDisposition(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+
+	// Plain text is displayed, special characters are encoded
+	file := uploadFile(upload, "été test.txt", readerForUpload, t)
+	code, header, err := getFileHeaders(upload, file)
 	if err != nil {
-		t.Fatalf("Error making file upload request : %s", err)
+		t.Fatalf("Failed to get file %s headers : %s", file.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on file %s headers. We expected 200", code, file.ID)
+	}
+	if header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("Missing X-Content-Type-Options header")
+	}
+	expected := "inline; filename=\"_t_ test.txt\"; filename*=UTF-8''%C3%A9t%C3%A9%20test.txt"
+	if header.Get("Content-Disposition") != expected {
+		t.Fatalf("Got Content-Disposition %s. We expected %s", header.Get("Content-Disposition"), expected)
 	}
 
-	defer resp.Body.Close()
-	responseBody, err := ioutil.ReadAll(resp.Body)
+	// Html must be downloaded
+	file = uploadFile(upload, "test.html", strings.NewReader("<html><script>alert(1)</script></html>"), t)
+	code, header, err = getFileHeaders(upload, file)
 	if err != nil {
-		t.Fatalf("Error reading file upload response body : %s", err)
+		t.Fatalf("Failed to get file %s headers : %s", file.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on file %s headers. We expected 200", code, file.ID)
 	}
+	expected = "attachment; filename=\"test.html\""
+	if header.Get("Content-Disposition") != expected {
+		t.Fatalf("Got Content-Disposition %s. We expected %s", header.Get("Content-Disposition"), expected)
+	}
+}
 
-	// Parse Json
-	file = new(common.File)
-	err = json.Unmarshal(responseBody, file)
+func TestCompression(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test.txt", readerForUpload, t)
+
+	code, header, content, err := getFileWithEncoding(upload, file, "gzip")
 	if err != nil {
-		t.Fatalf("Error unmarshalling file upload response : %s", err)
+		t.Fatalf("Failed to get file %s from upload %s : %s", file.ID, upload.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on file %s on upload %s. We expected 200", code, file.ID, upload.ID)
+	}
+	if header.Get("Content-Encoding") == "" {
+		t.Skip("Compression is disabled on this server")
+	}
+	if header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Got Content-Encoding %s. We expected gzip", header.Get("Content-Encoding"))
 	}
 
-	// Put it in upload infos
-	uploadInfo.Files[file.ID] = file
+	gzipReader, err := gzip.NewReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unable to read gzip content : %s", err)
+	}
+	body, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatalf("Unable to read gzip content : %s", err)
+	}
+	if string(body) != contentToUpload {
+		t.Fatalf("Did not get expected content (%s) on file %s on upload %s. We expected %s", string(body), file.ID, upload.ID, contentToUpload)
+	}
 
-	// Rewind reader
-	reader.Seek(0, 0)
+	// Clients not accepting compression get raw data
+	code, header, content, err = getFileWithEncoding(upload, file, "identity")
+	if err != nil {
+		t.Fatalf("Failed to get file %s from upload %s : %s", file.ID, upload.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on file %s on upload %s. We expected 200", code, file.ID, upload.ID)
+	}
+	if header.Get("Content-Encoding") != "" || content != contentToUpload {
+		t.Fatalf("Did not get expected raw content (%s) on file %s on upload %s. We expected %s", content, file.ID, upload.ID, contentToUpload)
+	}
+}
 
-	return
+func TestCompressionAtRest(t *testing.T) {
+	server := startLocalServer(t)
+	defer func(URL string) { plikURL = URL }(plikURL)
+	plikURL = server.URL
+	common.Config.CompressionAtRest = "gzip"
+	defer func() { common.Config.CompressionAtRest = "" }()
+
+	// The http client accepts gzip and gets the stored data as is
+	upload := createUpload(&common.Upload{}, t)
+	file1 := uploadFile(upload, "test1.txt", readerForUpload, t)
+	file2 := uploadFile(upload, "test2.txt", readerForUpload, t)
+	stored, err := metadataBackend.GetMetaDataBackend().Get(common.RootContext().Fork("get metadata"), upload.ID)
+	if err != nil || stored.Files[file1.ID].Encoding != "gzip" || stored.Files[file2.ID].Encoding != "gzip" {
+		t.Fatalf("Files of upload %s were not stored compressed : %s", upload.ID, err)
+	}
+
+	test("getUpload", upload, nil, 200, t)
+	test("getFile", upload, file1, 200, t)
+	test("getFile", upload, file2, 200, t)
+
+	code, _, body, err := apiRequest("GET", "/uploads/"+upload.ID+"/files/"+file1.ID+"/"+file1.Name, "", nil, "")
+	if err != nil || code != 200 || string(body) != contentToUpload {
+		t.Fatalf("Failed to get file %s stored compressed : %d %s %s", file1.ID, code, err, body)
+	}
 }
 
-func getUpload(uploadID string) (httpCode int, upload *common.Upload, err error) {
+func TestCompressionAtRestRoundTrip(t *testing.T) {
+	server := startLocalServer(t)
+	defer func(URL string) { plikURL = URL }(plikURL)
+	plikURL = server.URL
+	common.Config.CompressionAtRest = "gzip"
+	defer func() { common.Config.CompressionAtRest = "" }()
 
-	var URL *url.URL
-	URL, err = url.Parse(plikURL + "/upload/" + uploadID)
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test.txt", readerForUpload, t)
+
+	// Clients accepting gzip get the stored data
+	code, header, content, err := getFileWithEncoding(upload, file, "gzip")
+	if err != nil || code != 200 || header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("We got http code %d and Content-Encoding %q on file %s stored compressed. We expected 200 gzip : %s", code, header.Get("Content-Encoding"), file.ID, err)
+	}
+	if header.Get("Content-Length") != "" && header.Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("Got Content-Length %s for a %d bytes compressed body", header.Get("Content-Length"), len(content))
+	}
+	gzipReader, err := gzip.NewReader(strings.NewReader(content))
 	if err != nil {
-		return
+		t.Fatalf("Unable to read gzip content : %s", err)
+	}
+	body, err := ioutil.ReadAll(gzipReader)
+	if err != nil || string(body) != contentToUpload {
+		t.Fatalf("Did not get expected content (%s) on file %s stored compressed. We expected %s : %s", string(body), file.ID, contentToUpload, err)
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest("GET", URL.String(), nil)
-	if err != nil {
-		return
+	// Others get it uncompressed
+	code, header, content, err = getFileWithEncoding(upload, file, "identity")
+	if err != nil || code != 200 || header.Get("Content-Encoding") != "" || content != contentToUpload {
+		t.Fatalf("Did not get expected raw content (%s) on file %s stored compressed : %d %s", content, file.ID, code, err)
+	}
+	if header.Get("Content-Length") != strconv.Itoa(len(contentToUpload)) {
+		t.Fatalf("Got Content-Length %s. We expected %d", header.Get("Content-Length"), len(contentToUpload))
 	}
 
-	req.Header.Set("User-Agent", "curl")
+	// Ranges are ranges of the uncompressed data
+	code, header, content, err = getFileRange(upload, file, "bytes=1-2")
+	if err != nil || code != 206 || content != contentToUpload[1:3] {
+		t.Fatalf("We got http code %d and content %q for a range of file %s stored compressed. We expected 206 %q : %s", code, content, file.ID, contentToUpload[1:3], err)
+	}
+	if header.Get("Content-Range") != fmt.Sprintf("bytes 1-2/%d", len(contentToUpload)) {
+		t.Fatalf("Got Content-Range %s for a range of file %s stored compressed", header.Get("Content-Range"), file.ID)
+	}
 
-	resp, err := client.Do(req)
+	// Compressed responses are whole, the range is ignored
+	req, _ := http.NewRequest("GET", plikURL+"/file/"+upload.ID+"/"+file.ID+"/"+file.Name, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=1-2")
+	code, header, rawBody, err := rawRequest(req)
+	if err != nil || code != 200 || header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("We got http code %d and Content-Encoding %q for a compressed range. We expected 200 gzip : %s", code, header.Get("Content-Encoding"), err)
+	}
+	gzipReader, err = gzip.NewReader(bytes.NewReader(rawBody))
 	if err != nil {
-		return
+		t.Fatalf("Unable to read gzip content : %s", err)
+	}
+	body, err = ioutil.ReadAll(gzipReader)
+	if err != nil || string(body) != contentToUpload {
+		t.Fatalf("Did not get expected content (%s) for a compressed range. We expected %s : %s", string(body), contentToUpload, err)
 	}
+}
 
-	httpCode = resp.StatusCode
-	responseBody, err := ioutil.ReadAll(resp.Body)
+func TestDedup(t *testing.T) {
+	upload1 := createUpload(&common.Upload{Removable: true}, t)
+	file1 := uploadFile(upload1, "test", readerForUpload, t)
+	upload2 := createUpload(&common.Upload{}, t)
+	file2 := uploadFile(upload2, "test", readerForUpload, t)
+
+	// Files with the same content must stay independent
+	test("removeFile", upload1, file1, 200, t)
+	test("getFile", upload1, file1, 404, t)
+	test("getFile", upload2, file2, 200, t)
+}
+
+func TestDigests(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	sum := sha256.Sum256([]byte(contentToUpload))
+	reprDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+	code, file, err := uploadFileWithDigest(upload, "test.txt", readerForUpload, reprDigest)
 	if err != nil {
-		return
+		t.Fatalf("Failed to upload file : %s", err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on upload with a valid digest. We expected 200", code)
+	}
+	if file.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Got sha256 %s. We expected %s", file.Sha256, hex.EncodeToString(sum[:]))
 	}
 
-	// Parse return
-	upload = new(common.Upload)
-	err = json.Unmarshal(responseBody, upload)
+	code, header, err := getFileHeaders(upload, file)
 	if err != nil {
-		return
+		t.Fatalf("Failed to get file %s headers : %s", file.ID, err)
+	}
+	if code != 200 {
+		t.Fatalf("We got http code %d on file %s headers. We expected 200", code, file.ID)
+	}
+	if !strings.Contains(header.Get("Repr-Digest"), reprDigest) {
+		t.Fatalf("Got Repr-Digest %s. We expected it to contain %s", header.Get("Repr-Digest"), reprDigest)
 	}
 
-	return
+	// Corrupted files are rejected
+	code, _, err = uploadFileWithDigest(upload, "test.txt", strings.NewReader("CORRUPTED"), reprDigest)
+	if err != nil {
+		t.Fatalf("Failed to upload file : %s", err)
+	}
+	if code != 400 {
+		t.Fatalf("We got http code %d on upload with an invalid digest. We expected 400", code)
+	}
 }
 
-func getFile(upload *common.Upload, file *common.File) (httpCode int, content string, err error) {
+func TestRange(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test.txt", readerForUpload, t)
 
-	var URL *url.URL
-	URL, err = url.Parse(plikURL + "/file/" + upload.ID + "/" + file.ID + "/" + file.Name)
+	code, header, content, err := getFileRange(upload, file, "bytes=2-")
 	if err != nil {
-		return
+		t.Fatalf("Failed to get file %s : %s", file.ID, err)
+	}
+	if code != 206 {
+		t.Fatalf("We got http code %d on a range request. We expected 206", code)
+	}
+	if content != contentToUpload[2:] {
+		t.Fatalf("Got content %s. We expected %s", content, contentToUpload[2:])
+	}
+	expectedContentRange := fmt.Sprintf("bytes 2-%d/%d", len(contentToUpload)-1, len(contentToUpload))
+	if header.Get("Content-Range") != expectedContentRange {
+		t.Fatalf("Got Content-Range %s. We expected %s", header.Get("Content-Range"), expectedContentRange)
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest("GET", URL.String(), nil)
+	code, _, content, err = getFileRange(upload, file, "bytes=-3")
 	if err != nil {
-		return
+		t.Fatalf("Failed to get file %s : %s", file.ID, err)
+	}
+	if code != 206 || content != contentToUpload[len(contentToUpload)-3:] {
+		t.Fatalf("We got http code %d and content %s on a suffix range request", code, content)
 	}
 
-	req.Header.Set("User-Agent", "curl")
+	code, _, _, err = getFileRange(upload, file, fmt.Sprintf("bytes=%d-", len(contentToUpload)))
+	if err != nil {
+		t.Fatalf("Failed to get file %s : %s", file.ID, err)
+	}
+	if code != 416 {
+		t.Fatalf("We got http code %d on an unsatisfiable range request. We expected 416", code)
+	}
+}
 
-	if upload.ProtectedByPassword {
-		req.Header.Set("Authorization", basicAuth)
+//
+//// Subs for creating uploads and uploading files
+//
+
+// uploadFileWithDigest sends the expected digest in a request trailer
+// like clients streaming files of unknown content do
+func TestTree(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	for _, filePath := range []string{"dir/sub/a.txt", "dir/b.txt"} {
+		code, file, err := uploadFileWithPath(upload, filePath, readerForUpload)
+		if err != nil || code != 200 {
+			t.Fatalf("Failed to upload %s : %d %s", filePath, code, err)
+		}
+		if file.Path != filePath || file.Name != path.Base(filePath) {
+			t.Fatalf("Got file %s with path %s. We expected %s", file.Name, file.Path, filePath)
+		}
+		upload.Files[file.ID] = file
 	}
+	uploadFile(upload, "c.txt", readerForUpload, t)
 
-	resp, err := client.Do(req)
+	for _, filePath := range []string{"dir/b.txt", "../d.txt", "/d.txt"} {
+		code, _, err := uploadFileWithPath(upload, filePath, readerForUpload)
+		if err != nil {
+			t.Fatalf("Failed to upload %s : %s", filePath, err)
+		}
+		if code != 400 {
+			t.Fatalf("We got http code %d uploading %s. We expected 400", code, filePath)
+		}
+	}
+
+	code, content, err := getTree(upload, "dir/sub/a.txt")
 	if err != nil {
-		return
+		t.Fatalf("Failed to get file by path : %s", err)
+	}
+	if code != 200 || content != contentToUpload {
+		t.Fatalf("We got http code %d and content %s getting a file by path", code, content)
 	}
 
-	httpCode = resp.StatusCode
-	body, err := ioutil.ReadAll(resp.Body)
+	expected := map[string][]string{
+		"":    {"c.txt", "dir/b.txt", "dir/sub/a.txt"},
+		"dir": {"dir/b.txt", "dir/sub/a.txt"},
+	}
+	for treePath, paths := range expected {
+		code, content, err = getTree(upload, treePath)
+		if err != nil || code != 200 {
+			t.Fatalf("Failed to list %s : %d %s", treePath, code, err)
+		}
+		var files []*common.File
+		if err = json.Unmarshal([]byte(content), &files); err != nil {
+			t.Fatalf("Failed to unmarshal the files of %s : %s", treePath, err)
+		}
+		var got []string
+		for _, file := range files {
+			got = append(got, file.FullPath())
+		}
+		if strings.Join(got, ",") != strings.Join(paths, ",") {
+			t.Fatalf("Got files %v in %s. We expected %v", got, treePath, paths)
+		}
+	}
+
+	code, _, err = getTree(upload, "dir/missing")
 	if err != nil {
-		return
+		t.Fatalf("Failed to get missing path : %s", err)
+	}
+	if code != 404 {
+		t.Fatalf("We got http code %d on a missing path. We expected 404", code)
 	}
 
-	content = string(body)
+	code, archive, err := getArchive(upload, "tree.zip")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get archive : %d %s", code, err)
+	}
+	files, err := readArchive("tree.zip", archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive : %s", err)
+	}
+	if files["dir/sub/a.txt"] != contentToUpload {
+		t.Fatalf("Missing dir/sub/a.txt in archive, got %v", files)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+	file := uploadFile(upload, "test", readerForUpload, t)
+	fileURL := fmt.Sprintf("%s/file/%s/%s/%s", plikURL, upload.ID, file.ID, "plop")
+
+	// Http libraries get the error and its code whatever their user agent
+	for _, accept := range []string{"", "*/*", "application/json, text/plain, */*"} {
+		code, _, result, err := getError(fileURL, accept)
+		if err != nil {
+			t.Fatalf("Failed to get error : %s", err)
+		}
+		if code != 404 || result.Code != common.ErrFileNotFound {
+			t.Fatalf("We got http code %d and error code %q with Accept %q. We expected 404 %s", code, result.Code, accept, common.ErrFileNotFound)
+		}
+	}
+
+	// Browsers are redirected to the web client
+	code, location, _, err := getError(fileURL, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	if err != nil {
+		t.Fatalf("Failed to get error : %s", err)
+	}
+	if code != 301 || !strings.Contains(location, "code="+common.ErrFileNotFound) {
+		t.Fatalf("We got http code %d to %s. We expected a redirection to the web client", code, location)
+	}
+
+	// Redirections don't keep the headers of the file being served
+	req, _ := http.NewRequest("GET", fileURL, nil)
+	req.Header.Set("Accept", "text/html")
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "application/pdf")
+	recorder.Header().Set("Content-Length", "1234")
+	recorder.Header().Set("Content-Disposition", "attachment; filename=\"test.pdf\"")
+	redirect(req, recorder, common.ErrInternal, "Failed to read file test.pdf", 500)
+	if recorder.Code != 301 || recorder.Header().Get("Content-Disposition") != "" || recorder.Header().Get("Content-Length") != "" || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("We got http code %d and headers %v on a redirection of a file download", recorder.Code, recorder.Header())
+	}
+
+	code, _, result, err := getError(plikURL+"/upload/f4s6f4sd4f56sd4f64sd6f4s64f6sd4f4s56df4s", "")
+	if err != nil {
+		t.Fatalf("Failed to get error : %s", err)
+	}
+	if code != 404 || result.Code != common.ErrUploadNotFound {
+		t.Fatalf("We got http code %d and error code %q on a missing upload. We expected 404 %s", code, result.Code, common.ErrUploadNotFound)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	doc := newAPIDocument()
+
+	code, _, body, err := apiRequest("GET", "/openapi.json", "", nil, "")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get OpenAPI document : %d %s", code, err)
+	}
+	served := new(openapi.Document)
+	if err = json.Unmarshal(body, served); err != nil {
+		t.Fatalf("Unable to decode OpenAPI document : %s", err)
+	}
+	if _, ok := served.Paths["/uploads/{uploadID}/files/{fileID}/{filename}"]; !ok {
+		t.Fatalf("Missing file download in OpenAPI document paths")
+	}
+
+	code, _, body, err = apiRequest("POST", "/uploads", "", strings.NewReader(`{"ttl":true}`), "application/json")
+	if err != nil {
+		t.Fatalf("Failed to create upload : %s", err)
+	}
+	result := new(common.Result)
+	json.Unmarshal(body, result)
+	if code != 400 || result.Code != common.ErrInvalidRequest {
+		t.Fatalf("We got http code %d and error code %q with an invalid ttl. We expected 400 %s", code, result.Code, common.ErrInvalidRequest)
+	}
+
+	code, _, body, err = apiRequest("POST", "/uploads", "", strings.NewReader(`{"ttl":60,"removable":true}`), "application/json")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to create upload : %d %s", code, err)
+	}
+	validateResponse(doc, "createUpload", body, t)
+	upload := new(common.Upload)
+	json.Unmarshal(body, upload)
+
+	form := new(bytes.Buffer)
+	multipartWriter := multipart.NewWriter(form)
+	part, _ := multipartWriter.CreateFormFile("file", "test.txt")
+	part.Write([]byte(contentToUpload))
+	multipartWriter.Close()
+	code, _, body, err = apiRequest("POST", "/uploads/"+upload.ID+"/files", upload.UploadToken, form, multipartWriter.FormDataContentType())
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to add file : %d %s %s", code, err, body)
+	}
+	validateResponse(doc, "addFile", body, t)
+	var files []*common.File
+	json.Unmarshal(body, &files)
+	if len(files) != 1 {
+		t.Fatalf("We got %d files. We expected 1", len(files))
+	}
+	file := files[0]
+
+	code, _, body, err = apiRequest("GET", "/uploads/"+upload.ID+"/files/"+file.ID+"/test.txt", "", nil, "")
+	if err != nil || code != 200 || string(body) != contentToUpload {
+		t.Fatalf("Failed to get file : %d %s %s", code, err, body)
+	}
+
+	code, _, body, err = apiRequest("GET", "/uploads/"+upload.ID, "", nil, "")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get upload : %d %s", code, err)
+	}
+	validateResponse(doc, "getUpload", body, t)
+
+	code, _, body, err = apiRequest("PATCH", "/uploads/"+upload.ID, upload.UploadToken, strings.NewReader(`{"ttl":120}`), "application/json")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to update upload : %d %s", code, err)
+	}
+	validateResponse(doc, "updateUpload", body, t)
+
+	code, _, body, err = apiRequest("DELETE", "/uploads/"+upload.ID, upload.UploadToken, nil, "")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to remove upload : %d %s", code, err)
+	}
+	validateResponse(doc, "removeUpload", body, t)
+
+	// Legacy routes still work during the deprecation window
+	resp, err := client.Get(plikURL + "/upload/" + upload.ID)
+	if err != nil {
+		t.Fatalf("Failed to get upload : %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 || resp.Header.Get("Deprecation") != "true" {
+		t.Fatalf("We got http code %d and Deprecation header %q on a legacy route", resp.StatusCode, resp.Header.Get("Deprecation"))
+	}
+}
+
+func TestConfig(t *testing.T) {
+	resp, err := client.Get(plikURL + "/config")
+	if err != nil {
+		t.Fatalf("Failed to get config : %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("Failed to get config : %d %s", resp.StatusCode, err)
+	}
+	if strings.Contains(string(body), "Secret") || strings.Contains(string(body), "BackendConfig") {
+		t.Fatalf("Private configuration exposed : %s", body)
+	}
+	validateResponse(newAPIDocument(), "getConfig", body, t)
+
+	config := new(common.PublicConfiguration)
+	json.Unmarshal(body, config)
+	if config.Version != common.GetVersion() || config.MaxFileSize <= 0 || len(config.Digests) == 0 {
+		t.Fatalf("Invalid config : %s", body)
+	}
+
+	code, _, body, err := apiRequest("GET", "/version", "", nil, "")
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get version : %d %s", code, err)
+	}
+	version := new(common.VersionResult)
+	json.Unmarshal(body, version)
+	if version.Version != common.GetVersion() {
+		t.Fatalf("We got version %q. We expected %q", version.Version, common.GetVersion())
+	}
+}
+
+func TestFileTooBig(t *testing.T) {
+	resp, err := client.Get(plikURL + "/config")
+	if err != nil {
+		t.Fatalf("Failed to get config : %s", err)
+	}
+	config := new(common.PublicConfiguration)
+	err = json.NewDecoder(resp.Body).Decode(config)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Unable to decode config : %s", err)
+	}
+
+	upload := createUpload(&common.Upload{}, t)
+
+	// The body of a request too big must not be sent
+	body := new(countingReader)
+	req, _ := http.NewRequest("POST", plikURL+"/upload/"+upload.ID+"/file", body)
+	req.ContentLength = int64(config.MaxFileSize) + 1<<20
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=plik")
+	req.Header.Set("Expect", "100-continue")
+	req.Header.Set("X-UploadToken", upload.UploadToken)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to add file : %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 413 || body.read > 0 {
+		t.Fatalf("We got http code %d after sending %d bytes of a request too big. We expected 413 before sending the body", resp.StatusCode, body.read)
+	}
+
+	// Files declared too big are rejected before reading them
+	form := new(bytes.Buffer)
+	multipartWriter := multipart.NewWriter(form)
+	multipartWriter.WriteField("size", fmt.Sprintf("%d", config.MaxFileSize+1))
+	part, _ := multipartWriter.CreateFormFile("file", "test.txt")
+	part.Write([]byte(contentToUpload))
+	multipartWriter.Close()
+	code, _, responseBody, err := apiRequest("POST", "/uploads/"+upload.ID+"/files", upload.UploadToken, form, multipartWriter.FormDataContentType())
+	if err != nil {
+		t.Fatalf("Failed to add file : %s", err)
+	}
+	result := new(common.Result)
+	json.Unmarshal(responseBody, result)
+	if code != 413 || result.Code != common.ErrFileTooBig {
+		t.Fatalf("We got http code %d and error code %q with a file declared too big. We expected 413 %s", code, result.Code, common.ErrFileTooBig)
+	}
+
+	code, uploadInfo, err := getUpload(upload.ID)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get upload : %d %s", code, err)
+	}
+	if len(uploadInfo.Files) != 0 {
+		t.Fatalf("Files too big were added to the upload")
+	}
+}
+
+func TestMultipleFilesRequest(t *testing.T) {
+	upload := createUpload(&common.Upload{}, t)
+
+	sum := sha256.Sum256([]byte(contentToUpload))
+	body, contentType := newForm(
+		formPart{name: "file", filename: "test1.txt", value: contentToUpload},
+		formPart{name: "path", value: "dir/test2.txt"},
+		formPart{name: "digest", value: "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"},
+		formPart{name: "file", filename: "ignored.txt", value: contentToUpload},
+		formPart{name: "file", filename: "test3.txt", value: contentToUpload},
+	)
+	code, _, responseBody, err := apiRequest("POST", "/uploads/"+upload.ID+"/files", upload.UploadToken, body, contentType)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to add files : %d %s %s", code, err, responseBody)
+	}
+	var files []*common.File
+	json.Unmarshal(responseBody, &files)
+	if len(files) != 3 || files[0].Name != "test1.txt" || files[1].FullPath() != "dir/test2.txt" || files[2].Name != "test3.txt" {
+		t.Fatalf("Invalid files : %s", responseBody)
+	}
+	for _, file := range files {
+		test("getFile", upload, file, 200, t)
+	}
+
+	// No file is added if one of them is invalid
+	body, contentType = newForm(
+		formPart{name: "file", filename: "test4.txt", value: contentToUpload},
+		formPart{name: "digest", value: "sha-256=:" + base64.StdEncoding.EncodeToString(make([]byte, 32)) + ":"},
+		formPart{name: "file", filename: "test5.txt", value: contentToUpload},
+	)
+	code, _, responseBody, err = apiRequest("POST", "/uploads/"+upload.ID+"/files", upload.UploadToken, body, contentType)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with an invalid file. We expected 400 : %s %s", code, err, responseBody)
+	}
+
+	// Legacy requests have a single file
+	body, contentType = newForm(
+		formPart{name: "file", filename: "test6.txt", value: contentToUpload},
+		formPart{name: "file", filename: "test7.txt", value: contentToUpload},
+	)
+	req, _ := http.NewRequest("POST", plikURL+"/upload/"+upload.ID+"/file", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-UploadToken", upload.UploadToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to add files : %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatalf("We got http code %d with several files in a legacy request. We expected 400", resp.StatusCode)
+	}
+
+	code, uploadInfo, err := getUpload(upload.ID)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to get upload : %d %s", code, err)
+	}
+	if len(uploadInfo.Files) != 3 {
+		t.Fatalf("We got %d files in the upload. We expected 3", len(uploadInfo.Files))
+	}
+}
+
+func TestCreateUploadWithFiles(t *testing.T) {
+	body, contentType := newForm(
+		formPart{name: "upload", value: `{"ttl":60,"removable":true}`},
+		formPart{name: "file", filename: "test1.txt", value: contentToUpload},
+		formPart{name: "file", filename: "test2.txt", value: contentToUpload},
+	)
+	resp, err := client.Post(plikURL+"/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("Failed to create upload : %s", err)
+	}
+	upload := new(common.Upload)
+	err = json.NewDecoder(resp.Body).Decode(upload)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("Failed to create upload : %d %s", resp.StatusCode, err)
+	}
+	if upload.TTL != 60 || !upload.Removable || upload.UploadToken == "" || len(upload.Files) != 2 {
+		t.Fatalf("Invalid upload : %v", upload)
+	}
+	for _, file := range upload.Files {
+		test("getFile", upload, file, 200, t)
+	}
+
+	// The upload is not created if a file is invalid
+	body, contentType = newForm(
+		formPart{name: "path", value: "../test.txt"},
+		formPart{name: "file", filename: "test.txt", value: contentToUpload},
+	)
+	code, _, responseBody, err := apiRequest("POST", "/uploads", "", body, contentType)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with an invalid file. We expected 400 : %s %s", code, err, responseBody)
+	}
+}
+
+func TestPutFile(t *testing.T) {
+	req, _ := http.NewRequest("PUT", plikURL+"/put/test.txt", strings.NewReader(contentToUpload))
+	code, header, body, err := rawRequest(req)
+	if err != nil || code != 200 || !strings.HasPrefix(header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("Failed to put file : %d %s %s", code, err, body)
+	}
+	resp, err := client.Get(strings.TrimSpace(string(body)))
+	if err != nil {
+		t.Fatalf("Failed to get file : %s", err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(content) != contentToUpload {
+		t.Fatalf("We got http code %d and content %q from the url of a raw upload", resp.StatusCode, content)
+	}
+
+	req, _ = http.NewRequest("POST", plikURL+"/", strings.NewReader(contentToUpload))
+	req.Header.Set("Accept", "application/json")
+	code, _, body, err = rawRequest(req)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to post file : %d %s %s", code, err, body)
+	}
+	validateResponse(newAPIDocument(), "putFile", body, t)
+	result := new(common.UploadResult)
+	json.Unmarshal(body, result)
+	if result.Upload == nil || result.Upload.UploadToken == "" || len(result.Upload.Files) != 1 || !strings.HasSuffix(result.URL, "/file") {
+		t.Fatalf("Invalid raw upload response : %s", body)
+	}
+	for _, file := range result.Upload.Files {
+		test("getFile", result.Upload, file, 200, t)
+	}
+
+	// The upload is not created if the file is invalid
+	req, _ = http.NewRequest("PUT", plikURL+"/test.txt", strings.NewReader(contentToUpload))
+	req.Header.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(make([]byte, 32))+":")
+	code, _, body, err = rawRequest(req)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with an invalid file. We expected 400 : %s %s", code, err, body)
+	}
+}
+
+func TestUploadOptions(t *testing.T) {
+	req, _ := http.NewRequest("PUT", plikURL+"/put/test.txt?ttl=1h&oneShot", strings.NewReader(contentToUpload))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plik-Comments", "raw upload")
+	code, _, body, err := rawRequest(req)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to put file with options : %d %s %s", code, err, body)
+	}
+	result := new(common.UploadResult)
+	json.Unmarshal(body, result)
+	if result.Upload == nil || result.Upload.TTL != 3600 || !result.Upload.OneShot || result.Upload.Comments != "raw upload" {
+		t.Fatalf("Options of the raw upload were not applied : %s", body)
+	}
+
+	code, _, body, err = apiRequest("POST", "/uploads?removable=true", "", strings.NewReader(`{"ttl":60}`), "application/json")
+	upload := new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 || upload.TTL != 60 || !upload.Removable {
+		t.Fatalf("Failed to create upload with json and query options : %d %s %s", code, err, body)
+	}
+
+	// Conflicting and invalid options are rejected
+	req, _ = http.NewRequest("PUT", plikURL+"/put/test.txt?ttl=1h", strings.NewReader(contentToUpload))
+	req.Header.Set("X-Plik-TTL", "2h")
+	code, _, body, err = rawRequest(req)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with conflicting query parameter and header. We expected 400 : %s %s", code, err, body)
+	}
+	code, _, body, err = apiRequest("POST", "/uploads?ttl=2h", "", strings.NewReader(`{"ttl":60}`), "application/json")
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with conflicting json body and query parameter. We expected 400 : %s %s", code, err, body)
+	}
+	for _, query := range []string{"ttl=1h&ttl=2d", "ttl=1h&TTL=2d", "TTL=2d&ttl=2d&Ttl=1h"} {
+		code, _, body, err = apiRequest("POST", "/uploads?"+query, "", nil, "")
+		errorResult := new(common.Result)
+		json.Unmarshal(body, errorResult)
+		if err != nil || code != 400 || errorResult.Code != common.ErrInvalidRequest {
+			t.Fatalf("We got http code %d with conflicting query parameters %s. We expected 400 %s : %s %s", code, query, common.ErrInvalidRequest, err, body)
+		}
+	}
+	req, _ = http.NewRequest("PUT", plikURL+"/put/test.txt", strings.NewReader(contentToUpload))
+	req.Header.Add("X-Plik-TTL", "1h")
+	req.Header.Add("X-Plik-TTL", "2h")
+	code, _, body, err = rawRequest(req)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with conflicting headers. We expected 400 : %s %s", code, err, body)
+	}
+
+	// The same value given several times is not a conflict
+	code, _, body, err = apiRequest("POST", "/uploads?ttl=1h&TTL=1h", "", nil, "")
+	upload = new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 || upload.TTL != 3600 {
+		t.Fatalf("Failed to create upload with a repeated query parameter : %d %s %s", code, err, body)
+	}
+	code, _, body, err = apiRequest("POST", "/uploads?ttl=abc", "", nil, "")
+	errorResult := new(common.Result)
+	json.Unmarshal(body, errorResult)
+	if err != nil || code != 400 || errorResult.Code != common.ErrInvalidTTL {
+		t.Fatalf("We got http code %d with an invalid ttl. We expected 400 %s : %s %s", code, common.ErrInvalidTTL, err, body)
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	for value, expected := range map[string]int{"90": 90, "90m": 5400, "2w": 1209600, "1d12h": 129600, "-1": -1, "3550w": 2147040000} {
+		if ttl, err := common.ParseTTL(value); err != nil || ttl != expected {
+			t.Fatalf("Parsed ttl %s as %d (%s). We expected %d", value, ttl, err, expected)
+		}
+	}
+	for _, value := range []string{"", "abc", "1x", "12h30", "-2", "h", "3551w", "2147483647w", "2147483647w2147483647w", "99999999999999999999s"} {
+		if _, err := common.ParseTTL(value); err == nil {
+			t.Fatalf("Invalid ttl %q was parsed", value)
+		}
+	}
+
+	code, _, body, err := apiRequest("POST", "/uploads?ttl=1d12h", "", nil, "")
+	upload := new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 || upload.TTL != 129600 || upload.ExpireAt == nil || upload.ExpireAt.Unix() != upload.Creation+129600 {
+		t.Fatalf("Invalid expiration of an upload with a 1d12h ttl : %d %s %s", code, err, body)
+	}
+	validateResponse(newAPIDocument(), "createUpload", body, t)
+
+	expireAt := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	code, _, body, err = apiRequest("POST", "/uploads", "", strings.NewReader(`{"expireAt":"`+expireAt.Format(time.RFC3339)+`"}`), "application/json")
+	upload = new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 || upload.ExpireAt == nil || !upload.ExpireAt.Equal(expireAt) {
+		t.Fatalf("Failed to create upload with an expiration date : %d %s %s", code, err, body)
+	}
+
+	expireAt = expireAt.Add(time.Hour)
+	code, _, body, err = apiRequest("PATCH", "/uploads/"+upload.ID, upload.UploadToken, strings.NewReader(`{"expireAt":"`+expireAt.Format(time.RFC3339)+`"}`), "application/json")
+	upload = new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 || upload.ExpireAt == nil || upload.ExpireAt.Sub(expireAt) > time.Second || expireAt.Sub(*upload.ExpireAt) > time.Second {
+		t.Fatalf("Failed to change the expiration date of an upload : %d %s %s", code, err, body)
+	}
+
+	// Both a ttl and an expiration date, or a date in the past are rejected
+	code, _, body, err = apiRequest("POST", "/uploads?ttl=1h", "", strings.NewReader(`{"expireAt":"`+expireAt.Format(time.RFC3339)+`"}`), "application/json")
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with both a ttl and an expiration date. We expected 400 : %s %s", code, err, body)
+	}
+	req, _ := http.NewRequest("PUT", plikURL+"/test.txt", strings.NewReader(contentToUpload))
+	req.Header.Set("X-Plik-ExpireAt", time.Now().Add(-time.Hour).Format(time.RFC3339))
+	code, _, body, err = rawRequest(req)
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with an expiration date in the past. We expected 400 : %s %s", code, err, body)
+	}
+}
+
+func TestTTLDurationBody(t *testing.T) {
+	for value, expected := range map[string]int{"90m": 5400, "1d12h": 129600} {
+		code, _, body, err := apiRequest("POST", "/uploads", "", strings.NewReader(`{"ttl":"`+value+`"}`), "application/json")
+		upload := new(common.Upload)
+		json.Unmarshal(body, upload)
+		if err != nil || code != 200 || upload.TTL != expected {
+			t.Fatalf("Invalid ttl of an upload created with a %s ttl : %d %s %s", value, code, err, body)
+		}
+		validateResponse(newAPIDocument(), "createUpload", body, t)
+
+		code, _, body, err = apiRequest("PATCH", "/uploads/"+upload.ID, upload.UploadToken, strings.NewReader(`{"ttl":"`+value+`"}`), "application/json")
+		upload = new(common.Upload)
+		json.Unmarshal(body, upload)
+		if err != nil || code != 200 || upload.ExpireAt == nil || time.Until(*upload.ExpireAt) < time.Duration(expected-60)*time.Second {
+			t.Fatalf("Invalid expiration of an upload updated with a %s ttl : %d %s %s", value, code, err, body)
+		}
+
+		req, _ := http.NewRequest("POST", plikURL+"/upload", strings.NewReader(`{"ttl":"`+value+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		code, _, body, err = rawRequest(req)
+		upload = new(common.Upload)
+		json.Unmarshal(body, upload)
+		if err != nil || code != 200 || upload.TTL != expected {
+			t.Fatalf("Invalid ttl of a legacy upload created with a %s ttl : %d %s %s", value, code, err, body)
+		}
+
+		req, _ = http.NewRequest("POST", plikURL+"/upload/"+upload.ID+"/ttl", strings.NewReader(`{"ttl":"`+value+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-UploadToken", upload.UploadToken)
+		code, _, body, err = rawRequest(req)
+		if err != nil || code != 200 {
+			t.Fatalf("Failed to update the ttl of a legacy upload with a %s ttl : %d %s %s", value, code, err, body)
+		}
+	}
+
+	// Invalid durations and out of range ttls are rejected with an invalid_ttl error
+	upload := createUpload(&common.Upload{}, t)
+	for _, request := range []struct{ method, path, token, body string }{
+		{"POST", "/api/v1/uploads", "", `{"ttl":"abc"}`},
+		{"PATCH", "/api/v1/uploads/" + upload.ID, upload.UploadToken, `{"ttl":"abc"}`},
+		{"POST", "/upload", "", `{"ttl":"abc"}`},
+		{"POST", "/upload/" + upload.ID + "/ttl", upload.UploadToken, `{"ttl":"abc"}`},
+		{"POST", "/api/v1/uploads", "", `{"ttl":"2147483647w"}`},
+		{"POST", "/api/v1/uploads", "", `{"ttl":1e12}`},
+	} {
+		req, _ := http.NewRequest(request.method, plikURL+request.path, strings.NewReader(request.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-UploadToken", request.token)
+		code, _, body, err := rawRequest(req)
+		errorResult := new(common.Result)
+		json.Unmarshal(body, errorResult)
+		if err != nil || code != 400 || errorResult.Code != common.ErrInvalidTTL {
+			t.Fatalf("We got http code %d with the invalid ttl %s on %s %s. We expected 400 %s : %s %s", code, request.body, request.method, request.path, common.ErrInvalidTTL, err, body)
+		}
+	}
+}
+
+func TestExpirationWarning(t *testing.T) {
+	code, _, body, err := apiRequest("POST", "/uploads", "", strings.NewReader(`{"ttl":3600,"email":"Bob <bob@example.com>"}`), "application/json")
+	upload := new(common.Upload)
+	json.Unmarshal(body, upload)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to create upload with an email : %d %s %s", code, err, body)
+	}
+
+	// The email of the owner is private
+	_, uploadInfo, err := getUpload(upload.ID)
+	if err != nil || uploadInfo.Email != "" {
+		t.Fatalf("Got the email of the owner of upload %s : %s", upload.ID, err)
+	}
+
+	code, _, body, err = apiRequest("POST", "/uploads", "", strings.NewReader(`{"email":"bob"}`), "application/json")
+	if err != nil || code != 400 {
+		t.Fatalf("We got http code %d with an invalid email. We expected 400 : %s %s", code, err, body)
+	}
+
+	// Extend links are signed by the server
+	code, _, result, err := getError(fmt.Sprintf("%s/extend/%s/%d/invalid", plikURL, upload.ID, upload.Creation+3600), "")
+	if err != nil || code != 403 || result.Code != common.ErrInvalidSignature {
+		t.Fatalf("We got http code %d and error code %q with an invalid extend link. We expected 403 %s : %s", code, result.Code, common.ErrInvalidSignature, err)
+	}
+}
+
+// TestExpiringUploads runs the expiration warnings, the extend links and
+// the cleaning of expired uploads in the test process with its own backends
+func TestExpiringUploads(t *testing.T) {
+	server := startLocalServer(t)
+
+	warnings := make(chan *expirationWarning, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		warning := new(expirationWarning)
+		json.NewDecoder(req.Body).Decode(warning)
+		warnings <- warning
+	}))
+	defer webhook.Close()
+
+	common.Config.ExpirationWebhook = webhook.URL
+	defer func() { common.Config.ExpirationWebhook = "" }()
+	ctx := common.RootContext().Fork("test")
+
+	upload := common.NewUpload()
+	upload.Create()
+	upload.TTL = 3600
+	if err = metadataBackend.GetMetaDataBackend().Create(ctx.Fork("create metadata"), upload); err != nil {
+		t.Fatalf("Unable to create upload : %s", err)
+	}
+
+	// Owners are warned once with a signed extend link
+	warnExpiringUploads(ctx)
+	var warning *expirationWarning
+	select {
+	case warning = <-warnings:
+	default:
+		t.Fatalf("The webhook got no warning for upload %s", upload.ID)
+	}
+	if warning.ID != upload.ID || warning.ExpireAt.Unix() != upload.Creation+3600 || !strings.HasPrefix(warning.ExtendURL, server.URL+"/extend/"+upload.ID+"/") {
+		t.Fatalf("Invalid warning for upload %s : %+v", upload.ID, warning)
+	}
+	warnExpiringUploads(ctx)
+	if len(warnings) != 0 {
+		t.Fatalf("Upload %s was warned twice", upload.ID)
+	}
+
+	// Opening the link only shows a confirmation page
+	resp, err := client.Get(warning.ExtendURL)
+	if err != nil {
+		t.Fatalf("Failed to open extend link : %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("We got http code %d opening the extend link. We expected a confirmation page", resp.StatusCode)
+	}
+	upload, err = metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), upload.ID)
+	if err != nil || upload.TTL != 3600 {
+		t.Fatalf("Upload %s was extended by opening the extend link : %s", upload.ID, err)
+	}
+
+	// The link extends the upload once
+	req, _ := http.NewRequest("POST", warning.ExtendURL, nil)
+	code, _, body, err := rawRequest(req)
+	if err != nil || code != 200 {
+		t.Fatalf("Failed to extend upload : %d %s %s", code, err, body)
+	}
+	upload, err = metadataBackend.GetMetaDataBackend().Get(ctx.Fork("get metadata"), upload.ID)
+	if err != nil || upload.TTL <= 3600 {
+		t.Fatalf("Upload %s was not extended : %s", upload.ID, err)
+	}
+
+	req, _ = http.NewRequest("POST", warning.ExtendURL, nil)
+	code, _, body, err = rawRequest(req)
+	errorResult := new(common.Result)
+	json.Unmarshal(body, errorResult)
+	if err != nil || code != 409 || errorResult.Code != common.ErrInvalidRequest {
+		t.Fatalf("We got http code %d using an extend link twice. We expected 409 %s : %s %s", code, common.ErrInvalidRequest, err, body)
+	}
+
+	// Expired uploads are removed and leave a tombstone
+	upload = common.NewUpload()
+	upload.Create()
+	upload.Creation -= 7200
+	upload.TTL = 3600
+	if err = metadataBackend.GetMetaDataBackend().Create(ctx.Fork("create metadata"), upload); err != nil {
+		t.Fatalf("Unable to create upload : %s", err)
+	}
+	removeExpiredUpload(ctx, upload.ID)
+
+	code, _, result, err := getError(server.URL+"/api/v1/uploads/"+upload.ID, "")
+	if err != nil || code != 410 || result.Code != common.ErrUploadExpired {
+		t.Fatalf("We got http code %d and error code %q for a removed expired upload. We expected 410 %s : %s", code, result.Code, common.ErrUploadExpired, err)
+	}
+}
+
+func uploadFileWithPath(uploadInfo *common.Upload, filePath string, reader *strings.Reader) (httpCode int, file *common.File, err error) {
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		err := multipartWriter.WriteField("path", filePath)
+		if err == nil {
+			var part io.Writer
+			part, err = multipartWriter.CreateFormFile("file", path.Base(filePath))
+			if err == nil {
+				_, err = io.Copy(part, reader)
+			}
+		}
+		if err == nil {
+			err = multipartWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", plikURL+"/upload/"+uploadInfo.ID+"/file", pipeReader)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	req.Header.Set("X-ClientApp", "cli_client")
+	req.Header.Set("X-UploadToken", uploadInfo.UploadToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	// Rewind reader
+	reader.Seek(0, 0)
+
+	file = new(common.File)
+	err = json.Unmarshal(responseBody, file)
+	return
+}
+
+func uploadFileWithDigest(uploadInfo *common.Upload, name string, reader *strings.Reader, reprDigest string) (httpCode int, file *common.File, err error) {
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/upload/" + uploadInfo.ID + "/file")
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", URL.String(), pipeReader)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	req.Header.Set("X-ClientApp", "cli_client")
+	req.Header.Set("X-UploadToken", uploadInfo.UploadToken)
+	req.Trailer = http.Header{"Repr-Digest": nil}
+
+	go func() {
+		part, err := multipartWriter.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, reader)
+		}
+		if err == nil {
+			err = multipartWriter.Close()
+		}
+		req.Trailer.Set("Repr-Digest", reprDigest)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	// Rewind reader
+	reader.Seek(0, 0)
+
+	file = new(common.File)
+	err = json.Unmarshal(responseBody, file)
+	return
+}
+
+// startLocalServer starts the local server once with the default
+// configuration. Tests restore the options they change.
+func startLocalServer(t *testing.T) *httptest.Server {
+	if localServer != nil {
+		return localServer
+	}
+
+	var err error
+	localDirectory, err = ioutil.TempDir("", "plik")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory : %s", err)
+	}
+	localServer = httptest.NewServer(newRouter())
+
+	log = common.Log()
+	common.Config = common.NewConfiguration()
+	common.Config.MetadataBackendConfig = map[string]interface{}{"Directory": localDirectory}
+	common.Config.DataBackend = "file"
+	common.Config.DataBackendConfig = map[string]interface{}{"Directory": localDirectory}
+	common.Config.PublicURL = localServer.URL
+	common.Config.ExtendSecret = "secret"
+	metadataBackend.Initialize()
+	dataBackend.Initialize()
+	return localServer
+}
+
+func createUpload(uploadParams *common.Upload, t *testing.T) (upload *common.Upload) {
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/upload")
+	if err != nil {
+		t.Fatalf("Error parsing url : %s", err)
+	}
+
+	var j []byte
+	j, err = json.Marshal(uploadParams)
+	if err != nil {
+		t.Fatalf("Error marshalling json : %s", err)
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", URL.String(), bytes.NewBuffer(j))
+	if err != nil {
+		t.Fatalf("Error creating request : %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-ClientApp", "go_test")
+	req.Header.Set("Referer", plikURL)
+
+	var resp *http.Response
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Error creating upload : %s", err)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response body : %s", err)
+	}
+
+	basicAuth = resp.Header.Get("Authorization")
+
+	// Parse Json
+	upload = new(common.Upload)
+	err = json.Unmarshal(body, upload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling json into upload : %s", err)
+	}
+
+	return
+}
+
+func uploadFile(uploadInfo *common.Upload, name string, reader *strings.Reader, t *testing.T) (file *common.File) {
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+
+	go func() error {
+		part, err := multipartWriter.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Error creating multipart form : %s", err)
+		}
+
+		_, err = io.Copy(part, reader)
+		if err != nil {
+			t.Fatalf("Error copying file data to multipart part : %s", err)
+		}
+
+		err = multipartWriter.Close()
+		return pipeWriter.CloseWithError(err)
+	}()
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/upload/" + uploadInfo.ID + "/file")
+	if err != nil {
+		t.Fatalf("Error parsing url : %s", err)
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", URL.String(), pipeReader)
+	if err != nil {
+		t.Fatalf("Error creating file upload request : %s", err)
+	}
+
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	req.Header.Set("X-ClientApp", "cli_client")
+	req.Header.Set("X-UploadToken", uploadInfo.UploadToken)
+
+	if uploadInfo.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	var resp *http.Response
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Error making file upload request : %s", err)
+	}
+
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading file upload response body : %s", err)
+	}
+
+	// Parse Json
+	file = new(common.File)
+	err = json.Unmarshal(responseBody, file)
+	if err != nil {
+		t.Fatalf("Error unmarshalling file upload response : %s", err)
+	}
+
+	// Put it in upload infos
+	uploadInfo.Files[file.ID] = file
+
+	// Rewind reader
+	reader.Seek(0, 0)
+
+	return
+}
+
+func getUpload(uploadID string) (httpCode int, upload *common.Upload, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/upload/" + uploadID)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	// Parse return
+	upload = new(common.Upload)
+	err = json.Unmarshal(responseBody, upload)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func getFile(upload *common.Upload, file *common.File) (httpCode int, content string, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/file/" + upload.ID + "/" + file.ID + "/" + file.Name)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	content = string(body)
+
+	return
+}
+
+func getTree(upload *common.Upload, treePath string) (httpCode int, content string, err error) {
+	var req *http.Request
+	req, err = http.NewRequest("GET", plikURL+"/tree/"+upload.ID+"/"+treePath, nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	content = string(body)
+	return
+}
+
+func getFileHeaders(upload *common.Upload, file *common.File) (httpCode int, header http.Header, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/file/" + upload.ID + "/" + file.ID + "/" + file.Name)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("HEAD", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	header = resp.Header
+
+	return
+}
+
+func getFileWithEncoding(upload *common.Upload, file *common.File, acceptEncoding string) (httpCode int, header http.Header, content string, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/file/" + upload.ID + "/" + file.ID + "/" + file.Name)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	header = resp.Header
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	content = string(body)
+
+	return
+}
+
+func getFileRange(upload *common.Upload, file *common.File, rangeHeader string) (httpCode int, header http.Header, content string, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/file/" + upload.ID + "/" + file.ID + "/" + file.Name)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Range", rangeHeader)
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	header = resp.Header
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	content = string(body)
+
+	return
+}
+
+func getPreview(upload *common.Upload, file *common.File) (httpCode int, content string, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/preview/" + upload.ID + "/" + file.ID)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	content = string(body)
+
+	return
+}
+
+func getArchive(upload *common.Upload, name string) (httpCode int, content []byte, err error) {
+
+	var URL *url.URL
+	URL, err = url.Parse(plikURL + "/archive/" + upload.ID + "/" + name)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+
+	if upload.ProtectedByPassword {
+		req.Header.Set("Authorization", basicAuth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	httpCode = resp.StatusCode
+	content, err = ioutil.ReadAll(resp.Body)
+
+	return
+}
+
+func readArchive(name string, content []byte) (files map[string]string, err error) {
+	files = make(map[string]string)
+
+	if strings.HasSuffix(name, ".zip") {
+		var zipReader *zip.Reader
+		zipReader, err = zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return
+		}
+		for _, f := range zipReader.File {
+			var rc io.ReadCloser
+			rc, err = f.Open()
+			if err != nil {
+				return
+			}
+			var b []byte
+			b, err = ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return
+			}
+			files[f.Name] = string(b)
+		}
+		return
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		var header *tar.Header
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return
+		}
+		var b []byte
+		b, err = ioutil.ReadAll(tarReader)
+		if err != nil {
+			return
+		}
+		files[header.Name] = string(b)
+	}
+}
+
+func getError(URL string, accept string) (httpCode int, location string, result *common.Result, err error) {
+	var req *http.Request
+	req, err = http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	// Redirections to the web client are not followed
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	location = resp.Header.Get("Location")
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	result = new(common.Result)
+	if httpCode != 301 {
+		err = json.Unmarshal(responseBody, result)
+	}
+	return
+}
+
+func apiRequest(method string, path string, uploadToken string, body io.Reader, contentType string) (httpCode int, header http.Header, responseBody []byte, err error) {
+	var req *http.Request
+	req, err = http.NewRequest(method, plikURL+"/api/v1"+path, body)
+	if err != nil {
+		return
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if uploadToken != "" {
+		req.Header.Set("X-UploadToken", uploadToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	header = resp.Header
+	responseBody, err = ioutil.ReadAll(resp.Body)
+	return
+}
+
+func rawRequest(req *http.Request) (httpCode int, header http.Header, body []byte, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	header = resp.Header
+	body, err = ioutil.ReadAll(resp.Body)
+	return
+}
+
+// formPart is a part of a multipart request, a file if filename is set
+type formPart struct {
+	name     string
+	filename string
+	value    string
+}
+
+func newForm(parts ...formPart) (body *bytes.Buffer, contentType string) {
+	body = new(bytes.Buffer)
+	multipartWriter := multipart.NewWriter(body)
+	for _, part := range parts {
+		if part.filename != "" {
+			writer, _ := multipartWriter.CreateFormFile(part.name, part.filename)
+			writer.Write([]byte(part.value))
+		} else {
+			multipartWriter.WriteField(part.name, part.value)
+		}
+	}
+	multipartWriter.Close()
+	return body, multipartWriter.FormDataContentType()
+}
+
+// countingReader is a request body counting the bytes
+// the client tried to send
+type countingReader struct {
+	read int
+}
+
+func (reader *countingReader) Read(p []byte) (n int, err error) {
+	reader.read += len(p)
+	return len(p), nil
+}
+
+// validateResponse checks the JSON response of the operation against its schema
+func validateResponse(doc *openapi.Document, operationID string, body []byte, t *testing.T) {
+	for _, item := range doc.Paths {
+		for _, operation := range *item {
+			if operation.OperationID != operationID {
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal(body, &value); err != nil {
+				t.Fatalf("Invalid %s response : %s", operationID, err)
+			}
+			if err := doc.Validate(operation.Responses["200"].Content["application/json"].Schema, value); err != nil {
+				t.Fatalf("Invalid %s response : %s", operationID, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("Missing operation %s", operationID)
+}
+
+func removeUpload(upload *common.Upload) (httpCode int, err error) {
+	var req *http.Request
+	req, err = http.NewRequest("DELETE", plikURL+"/upload/"+upload.ID, nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("X-UploadToken", upload.UploadToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	httpCode = resp.StatusCode
+	return
+}
+
+func updateTTL(upload *common.Upload, ttl int) (httpCode int, updated *common.Upload, err error) {
+	var req *http.Request
+	req, err = http.NewRequest("POST", plikURL+"/upload/"+upload.ID+"/ttl", strings.NewReader(fmt.Sprintf(`{"ttl":%d}`, ttl)))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("X-UploadToken", upload.UploadToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	httpCode = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
 
+	updated = new(common.Upload)
+	err = json.Unmarshal(body, updated)
 	return
 }
 
@@ -0,0 +1,273 @@
+/**
+
+    Plik upload server
+
+The MIT License (MIT)
+
+Copyright (c) <2015>
+	- Mathieu Bodjikian <mathieu@bodjikian.fr>
+	- Charles-Antoine Mathieu <skatkatt@root.gg>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+**/
+
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/root-gg/plik/server/common"
+	"github.com/root-gg/plik/server/compression"
+	"github.com/root-gg/plik/server/dataBackend"
+)
+
+// Images bigger than this are not decoded to avoid
+// exhausting memory with decompression bombs
+const maxImagePixels = 50000000
+
+// Generate creates a preview of the file and saves it in the data backend
+// as a derived object. Only images (png, jpeg, gif) and text files are
+// supported, file.Preview is left untouched for any other type of file.
+func Generate(ctx *common.PlikContext, upload *common.Upload, file *common.File) (err error) {
+	defer ctx.Finalize(err)
+
+	if !common.Config.PreviewEnabled {
+		return
+	}
+
+	if file.CurrentSize > int64(common.Config.PreviewMaxFileSize) {
+		ctx.Debugf("File is too big to generate a preview")
+		return
+	}
+
+	var generator func(io.Reader) ([]byte, string, error)
+	switch {
+	case file.Type == "image/png" || file.Type == "image/jpeg" || file.Type == "image/gif":
+		generator = thumbnail
+	case strings.HasPrefix(file.Type, "text/"):
+		generator = textPreview
+	default:
+		ctx.Debugf("No preview available for content type %s", file.Type)
+		return
+	}
+
+	// Read file from the data backend
+	reader, err := dataBackend.GetDataBackend().GetFile(ctx.Fork("get file"), upload, file.ID)
+	if err != nil {
+		err = ctx.EWarningf("Unable to get file : %s", err)
+		return
+	}
+	reader, err = compression.NewReader(file.Encoding, reader)
+	if err != nil {
+		err = ctx.EWarningf("Unable to uncompress file : %s", err)
+		return
+	}
+	defer reader.Close()
+
+	data, contentType, err := generator(reader)
+	if err != nil {
+		err = ctx.EWarningf("Unable to generate preview : %s", err)
+		return
+	}
+
+	// Save preview in the data backend
+	preview := new(common.File)
+	preview.ID = file.ID + ".preview"
+	preview.Name = file.Name
+	preview.Type = contentType
+	preview.Status = "uploaded"
+	preview.CurrentSize = int64(len(data))
+	preview.UploadDate = time.Now().Unix()
+
+	backendDetails, err := dataBackend.GetDataBackend().AddFile(ctx.Fork("save preview"), derivedUpload(upload, preview), preview, bytes.NewReader(data))
+	if err != nil {
+		err = ctx.EWarningf("Unable to save preview : %s", err)
+		return
+	}
+	preview.BackendDetails = backendDetails
+
+	file.Preview = preview
+	ctx.Infof("Preview %s successfully generated", preview.Type)
+
+	return
+}
+
+// Get returns a reader on the preview of the file
+func Get(ctx *common.PlikContext, upload *common.Upload, file *common.File) (reader io.ReadCloser, err error) {
+	if file.Preview == nil {
+		err = errors.New("No preview available")
+		return
+	}
+	return dataBackend.GetDataBackend().GetFile(ctx, derivedUpload(upload, file.Preview), file.Preview.ID)
+}
+
+// Remove deletes the preview of the file from the data backend
+func Remove(ctx *common.PlikContext, upload *common.Upload, file *common.File) (err error) {
+	if file.Preview == nil {
+		return
+	}
+	return dataBackend.GetDataBackend().RemoveFile(ctx, derivedUpload(upload, file.Preview), file.Preview.ID)
+}
+
+// Data backends look for objects in the files of the upload.
+// Derived objects are not part of it so data backends get
+// an upload copy with the derived object as the only file.
+func derivedUpload(upload *common.Upload, derived *common.File) *common.Upload {
+	u := *upload
+	u.Files = map[string]*common.File{derived.ID: derived}
+	return &u
+}
+
+// thumbnail scales down an image to fit in a PreviewThumbnailSize square.
+// Jpeg images stay jpeg, everything else is encoded to png to keep transparency.
+func thumbnail(reader io.Reader) (data []byte, contentType string, err error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+	if config.Width*config.Height > maxImagePixels {
+		err = errors.New("Image is too big")
+		return
+	}
+
+	var img image.Image
+	switch format {
+	case "png":
+		img, err = png.Decode(bytes.NewReader(content))
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(content))
+	case "gif":
+		img, err = gif.Decode(bytes.NewReader(content))
+	default:
+		err = errors.New("Unsupported image format " + format)
+	}
+	if err != nil {
+		return
+	}
+
+	img = scale(img, common.Config.PreviewThumbnailSize)
+
+	buf := new(bytes.Buffer)
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(buf, img)
+	}
+	data = buf.Bytes()
+
+	return
+}
+
+// scale resizes the image to fit in a size x size square keeping
+// its aspect ratio. Each pixel of the thumbnail is the average
+// of the source pixels it covers.
+func scale(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if size <= 0 || (width <= size && height <= size) {
+		return src
+	}
+
+	thumbWidth, thumbHeight := size, size
+	if width > height {
+		thumbHeight = height * size / width
+	} else {
+		thumbWidth = width * size / height
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := bounds.Min.Y + y*height/thumbHeight
+		y1 := bounds.Min.Y + (y+1)*height/thumbHeight
+		for x := 0; x < thumbWidth; x++ {
+			x0 := bounds.Min.X + x*width/thumbWidth
+			x1 := bounds.Min.X + (x+1)*width/thumbWidth
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					count++
+				}
+			}
+			if count > 0 {
+				dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / count), G: uint16(g / count), B: uint16(b / count), A: uint16(a / count)})
+			}
+		}
+	}
+
+	return dst
+}
+
+// textPreview keeps the first PreviewTextSize bytes of a text file.
+// The text is cut at the last complete line and is always served
+// as plain text so html files are not rendered.
+func textPreview(reader io.Reader) (data []byte, contentType string, err error) {
+	size := common.Config.PreviewTextSize
+	data, err = ioutil.ReadAll(io.LimitReader(reader, int64(size)+1))
+	if err != nil {
+		return
+	}
+
+	if len(data) > size {
+		data = data[:size]
+		if i := bytes.LastIndexByte(data, '\n'); i > 0 {
+			data = data[:i+1]
+		}
+	}
+
+	// Do not leave a truncated multi-byte character
+	for i := 0; i < utf8.UTFMax && len(data) > 0; i++ {
+		if r, s := utf8.DecodeLastRune(data); r != utf8.RuneError || s != 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+
+	contentType = "text/plain; charset=utf-8"
+	return
+}
@@ -172,7 +172,7 @@ function UploadCtrl($scope, $dialog, $route, $location, $api) {
         // Display error from download redirect
         var err = $location.search().err;
         if ( ! _.isUndefined(err) ) {
-            if ( err == "Invalid yubikey token" && $location.search().uri ) {
+            if ( $location.search().code == "yubikey_invalid" && $location.search().uri ) {
                 var uri = $location.search().uri.split("/");
                 $scope.load(uri[2]);
                 $scope.downloadWithYubikey(location.origin + "/file/" + uri[2] + "/" + uri[3] + "/" + uri[4]);
@@ -308,6 +308,21 @@ function UploadCtrl($scope, $dialog, $route, $location, $api) {
         return url
     };
 
+    $scope.hasPreview = function(file) {
+        if(!file || !file.metadata || !file.metadata.preview) return false;
+        return file.metadata.status == 'uploaded' && !$scope.upload.protectedByYubikey;
+    };
+
+    $scope.isImagePreview = function(file) {
+        if(!$scope.hasPreview(file)) return false;
+        return file.metadata.preview.fileType.indexOf('image/') == 0;
+    };
+
+    $scope.getPreviewUrl = function(file) {
+        if(!$scope.hasPreview(file)) return;
+        return location.origin + '/preview/' + $scope.upload.id + '/' + file.metadata.id;
+    };
+
     $scope.getPassword = function() {
         var opts = {
             backdrop: true,